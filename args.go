@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// hdiffz/hpatchz 的命令行参数，按添加顺序排列
+// 选项必须以 - 开头；路径规范化为绝对路径，不会被当成选项 (例如名为 -f 的文件)
+type cmdArgs struct {
+	list []string
+	err  error
+}
+
+func newArgs(opts ...string) *cmdArgs {
+	return (&cmdArgs{}).Opt(opts...)
+}
+
+func (a *cmdArgs) fail(err error) {
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// 添加选项
+func (a *cmdArgs) Opt(opts ...string) *cmdArgs {
+	for _, o := range opts {
+		o = strings.TrimSpace(o)
+		if len(o) < 2 || o[0] != '-' {
+			a.fail(fmt.Errorf("无效的选项 %q", o))
+			continue
+		}
+		a.list = append(a.list, o)
+	}
+	return a
+}
+
+// 添加路径
+func (a *cmdArgs) Path(paths ...string) *cmdArgs {
+	for _, p := range paths {
+		p, err := argPath(p)
+		if err != nil {
+			a.fail(err)
+			continue
+		}
+		a.list = append(a.list, p)
+	}
+	return a
+}
+
+// 添加带路径的选项，例如 -X-exe#stub.exe
+func (a *cmdArgs) OptPath(prefix, path string) *cmdArgs {
+	p, err := argPath(path)
+	if err != nil {
+		a.fail(err)
+		return a
+	}
+	return a.Opt(prefix + p)
+}
+
+func (a *cmdArgs) Build() ([]string, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	return append([]string{}, a.list...), nil
+}
+
+// 去掉粘贴路径时带上的空白和引号
+func cleanPath(p string) string {
+	return strings.TrimSpace(strings.Trim(strings.TrimSpace(p), `"`))
+}
+
+// 命令行中使用的路径: 绝对路径；无法取得绝对路径且以 - 开头时加上 .\
+func argPath(p string) (string, error) {
+	p = cleanPath(p)
+	if p == "" {
+		return "", errors.New("路径为空")
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	if strings.HasPrefix(p, "-") {
+		p = "." + string(filepath.Separator) + p
+	}
+	return p, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustAbs(t *testing.T, p string) string {
+	t.Helper()
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
+
+func TestCmdArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args *cmdArgs
+		want []string
+		err  string
+	}{
+		{name: "空", args: newArgs(), want: []string{}},
+		{name: "选项顺序", args: newArgs("-m", "-c-zstd-21-24").Opt("-f", "-d"), want: []string{"-m", "-c-zstd-21-24", "-f", "-d"}},
+		{name: "选项去掉空白", args: newArgs(" -f\t"), want: []string{"-f"}},
+		{name: "不是选项", args: newArgs("f"), err: "无效的选项"},
+		{name: "只有 -", args: newArgs("-"), err: "无效的选项"},
+		{name: "空选项", args: newArgs(""), err: "无效的选项"},
+		{name: "补丁命令", args: newArgs("--patch").Opt("-f").Path("old.bin", "p.hdiff", "new.bin"),
+			want: []string{"--patch", "-f", mustAbs(t, "old.bin"), mustAbs(t, "p.hdiff"), mustAbs(t, "new.bin")}},
+		{name: "路径和选项交替", args: newArgs("-m").Path("a").Opt("-f").Path("b"),
+			want: []string{"-m", mustAbs(t, "a"), "-f", mustAbs(t, "b")}},
+		{name: "以 - 开头的路径", args: newArgs().Path("-f", "-c-lzma"),
+			want: []string{mustAbs(t, "-f"), mustAbs(t, "-c-lzma")}},
+		{name: "带引号的路径", args: newArgs().Path(`"dir with space\new.bin"`),
+			want: []string{mustAbs(t, `dir with space\new.bin`)}},
+		{name: "前后有空白的路径", args: newArgs().Path("  old.bin \t", ` " new.bin" `),
+			want: []string{mustAbs(t, "old.bin"), mustAbs(t, "new.bin")}},
+		{name: "空路径", args: newArgs("-f").Path(""), err: "路径为空"},
+		{name: "只有引号的路径", args: newArgs().Path(`""`), err: "路径为空"},
+		{name: "只有空白的路径", args: newArgs().Path(" \t"), err: "路径为空"},
+		{name: "带路径的选项", args: newArgs("-f").OptPath("-X-exe#", "stub.exe").Path("a"),
+			want: []string{"-f", "-X-exe#" + mustAbs(t, "stub.exe"), mustAbs(t, "a")}},
+		{name: "带路径的选项以 - 开头", args: newArgs().OptPath("-X-exe#", `"-stub.exe"`),
+			want: []string{"-X-exe#" + mustAbs(t, "-stub.exe")}},
+		{name: "带路径的选项路径为空", args: newArgs().OptPath("-X-exe#", " "), err: "路径为空"},
+		{name: "保留第一个错误", args: newArgs("x").Path("").Opt("-f"), err: "无效的选项"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.args.Build()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v，应包含 %q", err, tt.err)
+				}
+				if got != nil {
+					t.Fatalf("出错时返回了参数 %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCmdArgsBuildCopy(t *testing.T) {
+	a := newArgs("-f")
+	got, _ := a.Build()
+	got[0] = "-d"
+	if again, _ := a.Build(); again[0] != "-f" {
+		t.Fatalf("Build 返回的切片与内部共享: %q", again)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"  ", ""},
+		{`""`, ""},
+		{`C:\a b\c.bin`, `C:\a b\c.bin`},
+		{`"C:\a b\c.bin"`, `C:\a b\c.bin`},
+		{` "C:\a b\c.bin" `, `C:\a b\c.bin`},
+		{"\tC:\\x\r\n", `C:\x`},
+		{`" C:\x "`, `C:\x`},
+		{`C:\dir\`, `C:\dir\`},
+	}
+	for _, tt := range tests {
+		if got := cleanPath(tt.in); got != tt.want {
+			t.Errorf("cleanPath(%q) = %q，应为 %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 任务的临时输出路径，放在目标同目录下，保证最后可以直接 rename
+func tempSibling(path, jobID string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+jobID+".tmp")
+}
+
+func checkOutputTarget(dst string, overwrite bool) error {
+	if _, err := os.Lstat(dst); err == nil && !overwrite {
+		return fmt.Errorf("已存在同名文件 %s，请检查路径是否正确或勾选覆盖同名文件(-f)", dst)
+	}
+	return nil
+}
+
+// 将临时输出移动到最终位置
+// 文件直接 rename 覆盖；文件夹先把已有目标移开，替换成功后再删除，替换失败时恢复原目标
+func commitOutput(tmp, dst string, overwrite bool) error {
+	if err := checkOutputTarget(dst, overwrite); err != nil {
+		return err
+	}
+	dstInfo, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return os.Rename(tmp, dst)
+	}
+	if err != nil {
+		return err
+	}
+	tmpInfo, err := os.Lstat(tmp)
+	if err != nil {
+		return err
+	}
+	if !dstInfo.IsDir() && !tmpInfo.IsDir() {
+		return os.Rename(tmp, dst)
+	}
+
+	backup := tmp + ".old"
+	if err := os.Rename(dst, backup); err != nil {
+		return fmt.Errorf("无法移走已有的 %s - %v", dst, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		if rerr := os.Rename(backup, dst); rerr != nil {
+			return fmt.Errorf("替换 %s 失败 - %v，原数据保留在 %s", dst, err, backup)
+		}
+		return fmt.Errorf("替换 %s 失败 - %v", dst, err)
+	}
+	_ = os.RemoveAll(backup)
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"hdiff-gui/bsdiff"
+	"hdiff-gui/hpatch"
+)
+
+// 生成/应用补丁使用的后端
+const (
+	backendTool = iota // hdiffz.exe
+	backendGo          // 内置的 bsdiff / hpatch 包，只支持单个文件
+)
+
+var (
+	backendNames     = []string{"hdiffz", "内置 (仅单文件 HDIFF13/HDIFFSF20/BSDIFF40)"}
+	diffBackendNames = []string{"hdiffz", "内置 bsdiff (BSDIFF40，仅单文件)"}
+)
+
+// hdiffz 不在程序目录时默认使用内置后端
+func defaultBackend() (int, error) {
+	if _, err := findTool("hdiffz.exe"); err != nil {
+		return backendGo, err
+	}
+	return backendTool, nil
+}
+
+// 用选定的后端生成补丁，opts 为 hdiffz 的选项参数；verify 对应 hdiffz 默认的补丁检查
+func (j *job) diffFile(opts []string, oldPath, newPath, out string, verify bool) error {
+	if j.Backend != backendGo {
+		args, err := newArgs(opts...).Path(oldPath, newPath, out).Build()
+		if err != nil {
+			return err
+		}
+		return j.runTool(args)
+	}
+	if getPathType(oldPath) != FileTypeFile || getPathType(newPath) != FileTypeFile {
+		return errors.New("内置差分引擎只支持单个文件，文件夹请使用 hdiffz")
+	}
+	j.Log.Info("内置差分引擎: bsdiff (BSDIFF40)")
+	if err := bsdiff.DiffFile(oldPath, newPath, out, verify); err != nil {
+		return err
+	}
+	if verify {
+		j.Log.Info("补丁检查通过")
+	}
+	return nil
+}
+
+// 用选定的后端把一个补丁应用到 oldPath，输出到 out
+func (j *job) patchFile(oldPath, patch, out string) error {
+	if j.Backend != backendGo {
+		args, err := newArgs("--patch").Opt(j.ExtraArgs...).Path(oldPath, patch, out).Build()
+		if err != nil {
+			return err
+		}
+		return j.runTool(args)
+	}
+	return j.applyBuiltin(oldPath, patch, out)
+}
+
+func (j *job) applyBuiltin(oldPath, patch, out string) error {
+	if getPathType(oldPath) == FileTypeDirectory {
+		return errors.New("内置应用器不支持文件夹补丁，请改用 hdiffz")
+	}
+	if h, err := readPatchHeader(patch); err == nil && h.Format == "BSDIFF40" {
+		return j.applyBsdiff(oldPath, patch, out)
+	}
+	f, err := os.Open(patch)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		var h *hpatch.Header
+		if h, err = hpatch.ReadHeader(f, info.Size()); err == nil {
+			j.Log.Infof("内置应用器: %s %s", h.Format, h.Compress)
+		}
+	}
+	f.Close()
+	if errors.Is(err, hpatch.ErrUnsupported) {
+		return fmt.Errorf("内置应用器%v，请改用 hdiffz", err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := hpatch.ApplyFile(oldPath, patch, out); err != nil {
+		return fmt.Errorf("应用补丁失败 - %v", err)
+	}
+	j.Log.Info("补丁已应用")
+	return nil
+}
+
+func (j *job) applyBsdiff(oldPath, patch, out string) error {
+	j.Log.Info("内置应用器: BSDIFF40")
+	old, err := os.ReadFile(oldPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(patch)
+	if err != nil {
+		return err
+	}
+	res, err := bsdiff.Patch(old, data)
+	if err != nil {
+		return fmt.Errorf("应用补丁失败 - %v", err)
+	}
+	if err := os.WriteFile(out, res, 0644); err != nil {
+		return err
+	}
+	j.Log.Info("补丁已应用")
+	return nil
+}
+
+func (mw *AppMainWindow) diffBackend() int {
+	if mw.PatchTab.BackendCombo == nil {
+		return backendTool
+	}
+	return mw.PatchTab.BackendCombo.CurrentIndex()
+}
+
+// 内置差分引擎固定输出 BSDIFF40，格式和匹配模式不可选；hdiffz 不支持 -s 时只能用内存匹配
+func (mw *AppMainWindow) updateDiffBackend() {
+	builtin := mw.diffBackend() == backendGo
+	if builtin {
+		mw.PatchTab.FormatCombo.SetCurrentIndex(formatIndex("-BSD"))
+	}
+	mw.PatchTab.FormatCombo.SetEnabled(!builtin)
+	streaming := mw.Tools.Has("-s")
+	if !streaming {
+		mw.PatchTab.MatchModeCombo.SetCurrentIndex(0)
+	}
+	mw.PatchTab.MatchModeCombo.SetEnabled(!builtin && streaming)
+	mw.updatePatchFormat()
+}
+
+func (mw *AppMainWindow) applyBackend() int {
+	if mw.ApplyTab.BackendCombo == nil {
+		return backendTool
+	}
+	return mw.ApplyTab.BackendCombo.CurrentIndex()
+}
@@ -0,0 +1,289 @@
+// Package bsdiff 用纯 Go 生成和应用标准 BSDIFF40 补丁，hdiffz 不可用时作为单文件的内置差分引擎
+//
+// 需要把新旧文件都读入内存，后缀数组约占旧文件大小的 16 倍内存
+package bsdiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/dsnet/compress/bzip2"
+)
+
+const magic = "BSDIFF40"
+
+// 与 bsdiff 相同的 8 字节有符号整数: 小端，最高位为符号位
+func putOff(buf []byte, x int64) {
+	u := uint64(x)
+	if x < 0 {
+		u = uint64(-x) | 1<<63
+	}
+	binary.LittleEndian.PutUint64(buf, u)
+}
+
+func getOff(buf []byte) int64 {
+	u := binary.LittleEndian.Uint64(buf)
+	x := int64(u &^ (1 << 63))
+	if u&(1<<63) != 0 {
+		x = -x
+	}
+	return x
+}
+
+func matchLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// 在后缀数组 I[st:en+1] 中二分查找与 target 最长的匹配
+func search(I []int, old, target []byte, st, en int) (pos, n int) {
+	for en-st >= 2 {
+		x := st + (en-st)/2
+		if bytes.Compare(old[I[x]:], target[:min(len(old)-I[x], len(target))]) < 0 {
+			st = x
+		} else {
+			en = x
+		}
+	}
+	x := matchLen(old[I[st]:], target)
+	y := matchLen(old[I[en]:], target)
+	if x > y {
+		return I[st], x
+	}
+	return I[en], y
+}
+
+func compress(w io.Writer, data []byte) error {
+	bw, err := bzip2.NewWriter(w, &bzip2.WriterConfig{Level: 9})
+	if err != nil {
+		return err
+	}
+	if _, err := bw.Write(data); err != nil {
+		return err
+	}
+	return bw.Close()
+}
+
+// 生成从 old 到 new 的 BSDIFF40 补丁
+func Diff(old, new []byte, w io.Writer) error {
+	I := qsufsort(old)
+	oldSize, newSize := len(old), len(new)
+
+	var ctrl bytes.Buffer
+	db := make([]byte, 0, newSize)
+	eb := make([]byte, 0, newSize)
+	var triple [24]byte
+
+	scan, pos, length := 0, 0, 0
+	lastScan, lastPos, lastOffset := 0, 0, 0
+	for scan < newSize {
+		oldScore := 0
+		scan += length
+		for scsc := scan; scan < newSize; scan++ {
+			pos, length = search(I, old, new[scan:], 0, oldSize)
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastOffset < oldSize && old[scsc+lastOffset] == new[scsc] {
+					oldScore++
+				}
+			}
+			if (length == oldScore && length != 0) || length > oldScore+8 {
+				break
+			}
+			if scan+lastOffset < oldSize && old[scan+lastOffset] == new[scan] {
+				oldScore--
+			}
+		}
+		if length == oldScore && scan != newSize {
+			continue
+		}
+
+		// 向前扩展上一段匹配
+		s, sf, lenf := 0, 0, 0
+		for i := 0; lastScan+i < scan && lastPos+i < oldSize; {
+			if old[lastPos+i] == new[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > sf*2-lenf {
+				sf, lenf = s, i
+			}
+		}
+		// 向后扩展当前匹配
+		lenb := 0
+		if scan < newSize {
+			s, sb := 0, 0
+			for i := 1; scan >= lastScan+i && pos >= i; i++ {
+				if old[pos-i] == new[scan-i] {
+					s++
+				}
+				if s*2-i > sb*2-lenb {
+					sb, lenb = s, i
+				}
+			}
+		}
+		// 两段重叠时找最佳分界
+		if lastScan+lenf > scan-lenb {
+			overlap := lastScan + lenf - (scan - lenb)
+			s, ss, lens := 0, 0, 0
+			for i := 0; i < overlap; i++ {
+				if new[lastScan+lenf-overlap+i] == old[lastPos+lenf-overlap+i] {
+					s++
+				}
+				if new[scan-lenb+i] == old[pos-lenb+i] {
+					s--
+				}
+				if s > ss {
+					ss, lens = s, i+1
+				}
+			}
+			lenf += lens - overlap
+			lenb -= lens
+		}
+
+		for i := 0; i < lenf; i++ {
+			db = append(db, new[lastScan+i]-old[lastPos+i])
+		}
+		extra := (scan - lenb) - (lastScan + lenf)
+		eb = append(eb, new[lastScan+lenf:lastScan+lenf+extra]...)
+
+		putOff(triple[0:], int64(lenf))
+		putOff(triple[8:], int64(extra))
+		putOff(triple[16:], int64((pos-lenb)-(lastPos+lenf)))
+		ctrl.Write(triple[:])
+
+		lastScan = scan - lenb
+		lastPos = pos - lenb
+		lastOffset = pos - scan
+	}
+
+	// 文件头记录控制块和差值块压缩后的长度，所以这两块先压缩到内存
+	var ctrlZ, dbZ bytes.Buffer
+	if err := compress(&ctrlZ, ctrl.Bytes()); err != nil {
+		return err
+	}
+	if err := compress(&dbZ, db); err != nil {
+		return err
+	}
+	var head [32]byte
+	copy(head[:], magic)
+	putOff(head[8:], int64(ctrlZ.Len()))
+	putOff(head[16:], int64(dbZ.Len()))
+	putOff(head[24:], int64(newSize))
+	for _, b := range [][]byte{head[:], ctrlZ.Bytes(), dbZ.Bytes()} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return compress(w, eb)
+}
+
+var errCorrupt = errors.New("BSDIFF40 补丁已损坏")
+
+// 新文件大小上限，应用时新文件整个放在内存中
+const MaxSize = 1 << 32
+
+// 应用 BSDIFF40 补丁，返回新数据
+func Patch(old, patch []byte) ([]byte, error) {
+	if len(patch) < 32 || string(patch[:8]) != magic {
+		return nil, errors.New("不是 BSDIFF40 补丁")
+	}
+	ctrlLen, dbLen, newSize := getOff(patch[8:]), getOff(patch[16:]), getOff(patch[24:])
+	// 分别比较，避免两个长度相加溢出
+	body := int64(len(patch) - 32)
+	if ctrlLen < 0 || dbLen < 0 || ctrlLen > body || dbLen > body-ctrlLen || newSize < 0 || newSize > MaxSize || newSize > math.MaxInt {
+		return nil, errCorrupt
+	}
+	open := func(b []byte) (io.ReadCloser, error) {
+		return bzip2.NewReader(bytes.NewReader(b), nil)
+	}
+	ctrl, err := open(patch[32 : 32+ctrlLen])
+	if err != nil {
+		return nil, err
+	}
+	defer ctrl.Close()
+	diff, err := open(patch[32+ctrlLen : 32+ctrlLen+dbLen])
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Close()
+	extra, err := open(patch[32+ctrlLen+dbLen:])
+	if err != nil {
+		return nil, err
+	}
+	defer extra.Close()
+
+	// 文件头和控制块中的大小都没有校验，按实际读到的数据逐步扩大缓冲
+	var new bytes.Buffer
+	var oldPos, newPos int64
+	var triple [24]byte
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, triple[:]); err != nil {
+			return nil, errCorrupt
+		}
+		x, y, z := getOff(triple[0:]), getOff(triple[8:]), getOff(triple[16:])
+		if x < 0 || y < 0 || x > newSize-newPos {
+			return nil, errCorrupt
+		}
+		if _, err := io.CopyN(&new, diff, x); err != nil {
+			return nil, errCorrupt
+		}
+		buf := new.Bytes()[newPos:]
+		for i := range buf {
+			if p := oldPos + int64(i); p >= 0 && p < int64(len(old)) {
+				buf[i] += old[p]
+			}
+		}
+		newPos += x
+		oldPos += x
+		if y > newSize-newPos {
+			return nil, errCorrupt
+		}
+		if _, err := io.CopyN(&new, extra, y); err != nil {
+			return nil, errCorrupt
+		}
+		newPos += y
+		oldPos += z
+	}
+	// 读到流结尾，bzip2 会在结尾检查 CRC，截断的补丁在这里报错
+	for _, r := range []io.Reader{ctrl, diff, extra} {
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err != io.EOF {
+			return nil, errCorrupt
+		}
+	}
+	return new.Bytes(), nil
+}
+
+// 按文件路径生成补丁；verify 为真时生成后立即应用一次，检查结果与新文件一致
+func DiffFile(oldPath, newPath, outPath string, verify bool) error {
+	old, err := os.ReadFile(oldPath)
+	if err != nil {
+		return err
+	}
+	new, err := os.ReadFile(newPath)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := Diff(old, new, &buf); err != nil {
+		return fmt.Errorf("生成补丁失败 - %v", err)
+	}
+	if verify {
+		res, err := Patch(old, buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("补丁检查失败 - %v", err)
+		}
+		if !bytes.Equal(res, new) {
+			return errors.New("补丁检查失败 - 应用结果与新文件不一致")
+		}
+	}
+	return os.WriteFile(outPath, buf.Bytes(), 0644)
+}
@@ -0,0 +1,164 @@
+package bsdiff
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// 旧数据的片段被移动、修改，中间插入新数据
+func testData(size int) (old, new []byte) {
+	rng := rand.New(rand.NewSource(int64(size)))
+	old = make([]byte, size)
+	rng.Read(old)
+	for i := 0; i+16 < size; i += 4096 {
+		part := append([]byte{}, old[i:min(i+3000, size)]...)
+		for k := 0; k < len(part); k += 61 {
+			part[k]++
+		}
+		new = append(new, part...)
+		insert := make([]byte, rng.Intn(100))
+		rng.Read(insert)
+		new = append(new, insert...)
+	}
+	return old, new
+}
+
+func TestRoundTrip(t *testing.T) {
+	old, new := testData(100 << 10)
+	tests := []struct {
+		name     string
+		old, new []byte
+	}{
+		{"修改", old, new},
+		{"相同", old, old},
+		{"旧文件为空", nil, new},
+		{"新文件为空", old, nil},
+		{"都为空", nil, nil},
+		{"文本", []byte("hello world, this is the old file\n"), []byte("hello brave new world, this is the new file\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch bytes.Buffer
+			if err := Diff(tt.old, tt.new, &patch); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(patch.Bytes(), []byte(magic)) {
+				t.Fatalf("补丁头 %q", patch.Bytes()[:8])
+			}
+			got, err := Patch(tt.old, patch.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.new) {
+				t.Fatalf("应用结果与新数据不一致 (%d / %d 字节)", len(got), len(tt.new))
+			}
+		})
+	}
+}
+
+// 三段数据都是标准 bzip2 流，bsdiff/bspatch 可以读取
+func TestStandardBzip2(t *testing.T) {
+	old, new := testData(20 << 10)
+	var patch bytes.Buffer
+	if err := Diff(old, new, &patch); err != nil {
+		t.Fatal(err)
+	}
+	p := patch.Bytes()
+	ctrlLen, dbLen := getOff(p[8:]), getOff(p[16:])
+	for _, b := range [][]byte{p[32 : 32+ctrlLen], p[32+ctrlLen : 32+ctrlLen+dbLen], p[32+ctrlLen+dbLen:]} {
+		if _, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(b))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDiffFile(t *testing.T) {
+	dir := t.TempDir()
+	old, new := testData(30 << 10)
+	oldPath, newPath, outPath := filepath.Join(dir, "old"), filepath.Join(dir, "new"), filepath.Join(dir, "p.bsdiff")
+	os.WriteFile(oldPath, old, 0644)
+	os.WriteFile(newPath, new, 0644)
+	if err := DiffFile(oldPath, newPath, outPath, true); err != nil {
+		t.Fatal(err)
+	}
+	patch, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Patch(old, patch); err != nil || !bytes.Equal(got, new) {
+		t.Fatalf("Patch err = %v", err)
+	}
+}
+
+func header(ctrlLen, dbLen, newSize int64) []byte {
+	var head [32]byte
+	copy(head[:], magic)
+	putOff(head[8:], ctrlLen)
+	putOff(head[16:], dbLen)
+	putOff(head[24:], newSize)
+	return head[:]
+}
+
+// 损坏的补丁只能返回错误，不能 panic 或按文件头分配内存
+func TestPatchCorrupt(t *testing.T) {
+	old, new := testData(10 << 10)
+	var valid bytes.Buffer
+	if err := Diff(old, new, &valid); err != nil {
+		t.Fatal(err)
+	}
+	body := valid.Bytes()[32:]
+	tests := []struct {
+		name  string
+		patch []byte
+	}{
+		{"长度相加溢出", append(header(1<<62, 1<<62, 100), body...)},
+		{"控制块超出补丁", append(header(int64(len(body))+1, 0, 100), body...)},
+		{"差值块超出补丁", append(header(10, int64(len(body)), 100), body...)},
+		{"负长度", append(header(-1, 10, 100), body...)},
+		{"负的新文件大小", append(header(10, 10, -1), body...)},
+		{"新文件过大", append(header(10, 10, 1<<62), body...)},
+		{"新文件大小与数据不符", append(header(getOff(valid.Bytes()[8:]), getOff(valid.Bytes()[16:]), 1<<31), body...)},
+		{"截断", valid.Bytes()[:valid.Len()-10]},
+		{"只有文件头", header(0, 0, 0)[:20]},
+		{"不是补丁", []byte("not a bsdiff patch at all, just text")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Patch(old, tt.patch); err == nil {
+				t.Fatal("应返回错误")
+			}
+		})
+	}
+}
+
+// 控制块声称的长度不能直接用来分配内存，几十字节的补丁不应触发按文件头大小的分配
+func TestPatchNoLargeAlloc(t *testing.T) {
+	var triple [24]byte
+	putOff(triple[0:], MaxSize)
+	var ctrl, empty bytes.Buffer
+	if err := compress(&ctrl, triple[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := compress(&empty, nil); err != nil {
+		t.Fatal(err)
+	}
+	patch := append(header(int64(ctrl.Len()), int64(empty.Len()), MaxSize), ctrl.Bytes()...)
+	patch = append(patch, empty.Bytes()...)
+	patch = append(patch, empty.Bytes()...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := Patch(nil, patch); err == nil {
+		t.Fatal("应返回错误")
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 16<<20 {
+		t.Fatalf("%d 字节的补丁分配了 %d 字节", len(patch), n)
+	}
+}
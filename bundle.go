@@ -0,0 +1,346 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 补丁包: 一个 zip，平铺存放补丁、清单、SHA256SUMS 和可选的发布说明
+// 签名文件 (SHA256SUMS.sig) 不计入 SHA256SUMS，签名覆盖的是 SHA256SUMS 本身
+const (
+	bundleExt       = ".hdbundle"
+	bundleSums      = "SHA256SUMS"
+	bundleSignature = "SHA256SUMS.sig"
+	bundleNotes     = "RELEASE_NOTES"
+)
+
+var zipMagic = []byte("PK\x03\x04")
+
+func bundlePathFor(patchPath string) string {
+	return strings.TrimSuffix(patchPath, filepath.Ext(patchPath)) + bundleExt
+}
+
+func isBundle(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, zipMagic)
+}
+
+// 发布说明在包内的文件名，保留原扩展名
+func bundleNotesName(notesPath string) string {
+	ext := filepath.Ext(notesPath)
+	if ext == "" {
+		ext = ".txt"
+	}
+	return bundleNotes + ext
+}
+
+// 写出补丁包，files 中的文件按文件名存放；sign 不为空时同时写入 SHA256SUMS 的签名
+func writeBundle(bundlePath string, files []string, notesPath string, sign func([]byte) ([]byte, error)) error {
+	type member struct{ name, path string }
+	var members []member
+	for _, f := range files {
+		members = append(members, member{filepath.Base(f), f})
+	}
+	if notesPath != "" {
+		members = append(members, member{bundleNotesName(notesPath), notesPath})
+	}
+
+	out, err := os.Create(bundlePath)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	var sums strings.Builder
+	for _, m := range members {
+		sum, err := addToZip(zw, m.name, m.path)
+		if err != nil {
+			zw.Close()
+			out.Close()
+			return err
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, m.name)
+	}
+	w, err := zw.Create(bundleSums)
+	if err == nil {
+		_, err = io.WriteString(w, sums.String())
+	}
+	if err == nil && sign != nil {
+		var sig []byte
+		if sig, err = sign([]byte(sums.String())); err == nil {
+			if w, err = zw.Create(bundleSignature); err == nil {
+				_, err = w.Write(sig)
+			}
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func addToZip(zw *zip.Writer, name, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w, err := zw.Create(name)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 解析 SHA256SUMS: "<sha256>  <文件名>"
+func parseSums(data []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s 格式错误: %s", bundleSums, line)
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return sums, sc.Err()
+}
+
+// 解开的补丁包
+type openedBundle struct {
+	Dir   string
+	Patch string // 包内的正向补丁
+	Notes string
+	Sums  []byte
+	Sig   []byte
+}
+
+// 只接受平铺的普通文件名，防止读写到目录之外
+func plainFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\:`) && filepath.Base(name) == name
+}
+
+// 把补丁包解到 dir，逐个核对 SHA256SUMS，并找出要应用的补丁
+func extractBundle(bundlePath, dir string) (*openedBundle, error) {
+	zr, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开补丁包 - %v", err)
+	}
+	defer zr.Close()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	b := &openedBundle{Dir: dir}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		if !plainFileName(f.Name) {
+			return nil, fmt.Errorf("补丁包中包含非法路径: %s", f.Name)
+		}
+		data, sum, err := extractZipFile(f, filepath.Join(dir, f.Name))
+		if err != nil {
+			return nil, fmt.Errorf("解压 %s 失败 - %v", f.Name, err)
+		}
+		switch {
+		case f.Name == bundleSums:
+			b.Sums = data
+		case f.Name == bundleSignature:
+			b.Sig = data
+		default:
+			got[f.Name] = sum
+		}
+	}
+	if b.Sums == nil {
+		return nil, fmt.Errorf("补丁包中缺少 %s", bundleSums)
+	}
+	sums, err := parseSums(b.Sums)
+	if err != nil {
+		return nil, err
+	}
+	for name, sum := range sums {
+		if got[name] == "" {
+			return nil, fmt.Errorf("补丁包中缺少 %s", name)
+		}
+		if got[name] != sum {
+			return nil, fmt.Errorf("补丁包中的 %s 与 %s 不一致，可能已损坏或被修改", name, bundleSums)
+		}
+	}
+	var rest []string
+	for name := range got {
+		if _, ok := sums[name]; !ok {
+			return nil, fmt.Errorf("补丁包中的 %s 未列入 %s", name, bundleSums)
+		}
+		switch {
+		case strings.HasPrefix(name, bundleNotes):
+			b.Notes = filepath.Join(dir, name)
+		case !strings.HasSuffix(name, manifestSuffix):
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+
+	// 有清单时使用清单中的正向补丁，否则包内只能有一个补丁
+	for _, name := range got {
+		if !strings.HasSuffix(name, manifestSuffix) {
+			continue
+		}
+		m, err := loadManifest(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range m.Patches {
+			if e.Direction == DirectionReverse {
+				continue
+			}
+			// 加密的补丁在清单中记录的是明文文件名
+			for _, name := range []string{e.File, e.File + encExt} {
+				if got[name] != "" {
+					b.Patch = filepath.Join(dir, name)
+					return b, nil
+				}
+			}
+		}
+	}
+	if len(rest) != 1 {
+		return nil, fmt.Errorf("补丁包中没有清单，且包含 %d 个补丁文件，无法确定要应用的补丁", len(rest))
+	}
+	b.Patch = filepath.Join(dir, rest[0])
+	return b, nil
+}
+
+func extractZipFile(f *zip.File, dst string) ([]byte, string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer rc.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, "", err
+	}
+	h := sha256.New()
+	var keep bytes.Buffer
+	w := io.MultiWriter(out, h)
+	// 元数据文件同时保留在内存中
+	if f.Name == bundleSums || f.Name == bundleSignature {
+		w = io.MultiWriter(w, &keep)
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		out.Close()
+		return nil, "", err
+	}
+	if err := out.Close(); err != nil {
+		return nil, "", err
+	}
+	return keep.Bytes(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 读取包内补丁的文件头
+func readBundleHeader(bundlePath string) (PatchHeader, error) {
+	zr, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return PatchHeader{}, err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Name == bundleSums || f.Name == bundleSignature || strings.HasPrefix(f.Name, bundleNotes) || strings.HasSuffix(f.Name, manifestSuffix) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return PatchHeader{}, err
+		}
+		buf := make([]byte, 64)
+		n, err := io.ReadFull(rc, buf)
+		rc.Close()
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return PatchHeader{}, err
+		}
+		h, err := parsePatchHeader(buf[:n])
+		if err != nil {
+			continue
+		}
+		h.Bundle = true
+		return h, nil
+	}
+	return PatchHeader{}, fmt.Errorf("补丁包中没有可识别的补丁")
+}
+
+// 应用前的补丁准备: 分卷先拼接，补丁包先解开校验，单独的补丁检查签名，加密的补丁解密到 workDir
+// 返回实际要应用的补丁文件
+func (j *job) preparePatch(patch, workDir string) (string, error) {
+	var err error
+	if base := volumeBase(patch); base != "" {
+		if patch, err = j.joinVolumes(base, workDir); err != nil {
+			return "", err
+		}
+	}
+	if isBundle(patch) {
+		if patch, err = j.openBundle(patch, workDir); err != nil {
+			return "", err
+		}
+	} else if err := j.checkPatchSignature(patch); err != nil {
+		return "", err
+	}
+	if isEncrypted(patch) {
+		return j.decryptPatch(patch, workDir)
+	}
+	return patch, nil
+}
+
+func (j *job) checkPatchSignature(patch string) error {
+	if err := j.checkFileSignature("补丁", patch); err != nil {
+		return err
+	}
+	manifestPath := manifestPathFor(strings.TrimSuffix(patch, encExt))
+	if _, err := os.Stat(manifestPath + sigExt); err == nil {
+		return j.checkFileSignature("清单", manifestPath)
+	}
+	return nil
+}
+
+func (j *job) openBundle(patch, workDir string) (string, error) {
+	j.Log.Info("解开补丁包: " + filepath.Base(patch))
+	b, err := extractBundle(patch, filepath.Join(workDir, baseNameNoExt(patch)+".bundle"))
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(b.Sums)
+	if err := j.checkSignature("补丁包", b.Sig, digest[:]); err != nil {
+		return "", err
+	}
+	j.Log.Infof("补丁包文件校验通过 (%s)", bundleSums)
+	if b.Notes != "" {
+		if data, err := os.ReadFile(b.Notes); err == nil {
+			j.Log.Info("发布说明:\r\n" + string(decodeOutput(data, Cp)))
+		}
+	}
+	return b.Patch, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lxn/walk"
+)
+
+// 补丁链分隔符，例如 "v1.0_to_v1.1.diff;v1.1_to_v1.2.diff"
+const patchListSep = ";"
+
+// 拆分补丁路径列表，去掉空白和粘贴时带上的引号
+func splitPatchList(text string) []string {
+	var patches []string
+	for _, p := range strings.Split(text, patchListSep) {
+		if p = cleanPath(p); p != "" {
+			patches = append(patches, p)
+		}
+	}
+	return patches
+}
+
+func joinPatchList(patches []string) string {
+	return strings.Join(patches, patchListSep)
+}
+
+// 依次应用补丁链，中间结果放在 workDir 中，最后一步输出到 outPath
+// 每一步都先用清单检查输入，再检查结果；最后一步没有清单时使用 refPath 校验
+// 返回最终结果是否经过了校验
+func (j *job) applyChain(oldPath string, patches []string, outPath, workDir, refPath string) (bool, error) {
+	input := oldPath
+	verified := false
+	for i, patch := range patches {
+		last := i == len(patches)-1
+		step := fmt.Sprintf("第 %d/%d 步 (%s)", i+1, len(patches), filepath.Base(patch))
+		fail := func(err error) (bool, error) {
+			if len(patches) == 1 {
+				return false, err
+			}
+			return false, fmt.Errorf("%s失败: %v", step, err)
+		}
+		if len(patches) > 1 {
+			j.Log.Info(step + "...")
+		}
+
+		patch, err := j.preparePatch(patch, workDir)
+		if err != nil {
+			return fail(err)
+		}
+		ref := ""
+		if last {
+			ref = refPath
+		}
+		expected, source, err := expectedResult(j, input, patch, ref)
+		if err != nil {
+			return fail(err)
+		}
+		out := outPath
+		if !last {
+			if err := os.MkdirAll(workDir, 0755); err != nil {
+				return fail(err)
+			}
+			out = filepath.Join(workDir, fmt.Sprintf("step%d", i+1))
+		}
+		if err := j.patchFile(input, patch, out); err != nil {
+			return fail(err)
+		}
+		if expected != nil {
+			if err := checkDigest(j, out, expected, source); err != nil {
+				return fail(err)
+			}
+		}
+		// 上一步的中间结果已用完
+		if input != oldPath {
+			_ = os.RemoveAll(input)
+		}
+		input = out
+		verified = expected != nil
+	}
+	return verified, nil
+}
+
+// 选择一个或多个补丁，多个补丁按文件名排序组成补丁链
+func (mw *AppMainWindow) selectPatchFiles(edit *walk.LineEdit) {
+	dlg := new(walk.FileDialog)
+	dlg.Title = "选择补丁文件 (可多选，按文件名顺序依次应用)"
+	dlg.Filter = "全部文件(*.*)|*.*|补丁文件 (*.diff)|*.diff|补丁包 (*" + bundleExt + ")|*" + bundleExt
+	if ok, _ := dlg.ShowOpenMultiple(mw.MainWindow); !ok || len(dlg.FilePaths) == 0 {
+		return
+	}
+	paths := append([]string{}, dlg.FilePaths...)
+	sort.Strings(paths)
+	edit.SetText(joinPatchList(paths))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/transform"
+)
+
+// Windows 代码页编号 -> x/text 编码
+// UTF-8(65001) 和 US-ASCII(20127) 不需要转换，不在表中
+var codePageEncodings = map[uintptr]encoding.Encoding{
+	437:   charmap.CodePage437,
+	850:   charmap.CodePage850,
+	852:   charmap.CodePage852,
+	855:   charmap.CodePage855,
+	858:   charmap.CodePage858,
+	860:   charmap.CodePage860,
+	862:   charmap.CodePage862,
+	863:   charmap.CodePage863,
+	865:   charmap.CodePage865,
+	866:   charmap.CodePage866,
+	874:   charmap.Windows874,
+	932:   japanese.ShiftJIS,
+	936:   simplifiedchinese.GBK,
+	949:   korean.EUCKR,
+	950:   traditionalchinese.Big5,
+	1250:  charmap.Windows1250,
+	1251:  charmap.Windows1251,
+	1252:  charmap.Windows1252,
+	1253:  charmap.Windows1253,
+	1254:  charmap.Windows1254,
+	1255:  charmap.Windows1255,
+	1256:  charmap.Windows1256,
+	1257:  charmap.Windows1257,
+	1258:  charmap.Windows1258,
+	10000: charmap.Macintosh,
+	20866: charmap.KOI8R,
+	20932: japanese.EUCJP,
+	21866: charmap.KOI8U,
+	28591: charmap.ISO8859_1,
+	28592: charmap.ISO8859_2,
+	28593: charmap.ISO8859_3,
+	28594: charmap.ISO8859_4,
+	28595: charmap.ISO8859_5,
+	28596: charmap.ISO8859_6,
+	28597: charmap.ISO8859_7,
+	28598: charmap.ISO8859_8,
+	28599: charmap.ISO8859_9,
+	28600: charmap.ISO8859_10,
+	28603: charmap.ISO8859_13,
+	28604: charmap.ISO8859_14,
+	28605: charmap.ISO8859_15,
+	28606: charmap.ISO8859_16,
+	50220: japanese.ISO2022JP,
+	51932: japanese.EUCJP,
+	51949: korean.EUCKR,
+	52936: simplifiedchinese.HZGB2312,
+	54936: simplifiedchinese.GB18030,
+}
+
+// 返回代码页对应的编码，UTF-8 或未知代码页返回 nil
+func encodingForCodePage(cp uintptr) encoding.Encoding {
+	return codePageEncodings[cp]
+}
+
+// 子进程输出 -> UTF-8
+// 输出本身已是合法 UTF-8 时原样返回（例如控制台切到了 65001），否则按代码页解码
+func decodeOutput(raw []byte, cp uintptr) []byte {
+	out, _, err := transform.Bytes(newAutoDecoder(cp), raw)
+	if err != nil {
+		return raw
+	}
+	return out
+}
+
+const (
+	decodeDetect = iota
+	decodeUTF8
+	decodeCodePage
+)
+
+// autoDecoder 流式判断输出是 UTF-8 还是系统代码页
+// 在遇到第一段非 ASCII 数据前一直透传，之后固定为其中一种模式。
+// 被 Read 截断的多字节序列返回 ErrShortSrc，由 transform.Reader 拼接下一块后再处理
+type autoDecoder struct {
+	fallback transform.Transformer
+	mode     int
+}
+
+func newAutoDecoder(cp uintptr) *autoDecoder {
+	d := &autoDecoder{}
+	if enc := encodingForCodePage(cp); enc != nil {
+		d.fallback = enc.NewDecoder()
+	}
+	return d
+}
+
+func (d *autoDecoder) Reset() {
+	d.mode = decodeDetect
+	if d.fallback != nil {
+		d.fallback.Reset()
+	}
+}
+
+func (d *autoDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if d.mode == decodeDetect {
+		i := 0
+		for i < len(src) && src[i] < utf8.RuneSelf {
+			i++
+		}
+		if i > len(dst) {
+			n := copy(dst, src)
+			return n, n, transform.ErrShortDst
+		}
+		copy(dst, src[:i])
+		if i == len(src) {
+			return i, i, nil
+		}
+		rest := src[i:]
+		// 去掉末尾可能不完整的字符后再判断
+		tail := len(rest)
+		if !atEOF {
+			for k := len(rest) - 1; k >= 0 && k >= len(rest)-utf8.UTFMax; k-- {
+				if utf8.RuneStart(rest[k]) {
+					if !utf8.FullRune(rest[k:]) {
+						tail = k
+					}
+					break
+				}
+			}
+		}
+		if tail == 0 {
+			return i, i, transform.ErrShortSrc
+		}
+		if utf8.Valid(rest[:tail]) || d.fallback == nil {
+			d.mode = decodeUTF8
+		} else {
+			d.mode = decodeCodePage
+		}
+		n, m, err := d.Transform(dst[i:], rest, atEOF)
+		return i + n, i + m, err
+	}
+	if d.mode == decodeCodePage {
+		return d.fallback.Transform(dst, src, atEOF)
+	}
+	n := copy(dst, src)
+	if n < len(src) {
+		return n, n, transform.ErrShortDst
+	}
+	return n, n, nil
+}
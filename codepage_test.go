@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/text/transform"
+)
+
+// 各代码页下 hdiffz 输出的样例字节
+var codePageSamples = []struct {
+	name string
+	cp   uintptr
+	raw  []byte
+	want string
+}{
+	{"GBK", 936, []byte("\xd5\xfd\xd4\xda\xb4\xb4\xbd\xa8\xb2\xb9\xb6\xa1: old.bin\r\n"), "正在创建补丁: old.bin\r\n"},
+	{"Big5", 950, []byte("\xa5\xbf\xa6\x62\xab\xd8\xa5\xdf\xb8\xc9\xa4\x42\r\n"), "正在建立補丁\r\n"},
+	{"Shift-JIS", 932, []byte("\x83\x74\x83\x40\x83\x43\x83\x8b\x82\xf0\x8d\xec\x90\xac\x92\x86\r\n"), "ファイルを作成中\r\n"},
+	{"Windows-1252", 1252, []byte("caf\xe9 \x80 na\xefve\r\n"), "café € naïve\r\n"},
+}
+
+func TestDecodeOutputCodePages(t *testing.T) {
+	for _, s := range codePageSamples {
+		t.Run(s.name, func(t *testing.T) {
+			if got := string(decodeOutput(s.raw, s.cp)); got != s.want {
+				t.Fatalf("got %q，应为 %q", got, s.want)
+			}
+		})
+	}
+}
+
+// 输出已经是 UTF-8 (控制台为 65001 或工具自己输出 UTF-8) 时不按代码页解码
+func TestDecodeOutputUTF8Detect(t *testing.T) {
+	for _, cp := range []uintptr{936, 950, 932, 1252, 65001} {
+		in := "hdiffz 补丁 ファイル café\r\n"
+		if got := string(decodeOutput([]byte(in), cp)); got != in {
+			t.Errorf("代码页 %d: got %q，应为 %q", cp, got, in)
+		}
+	}
+	// 不认识的代码页按原样输出
+	raw := []byte("\xd5\xfd\xd4\xda")
+	if got := decodeOutput(raw, 12345); !bytes.Equal(got, raw) {
+		t.Errorf("未知代码页: got %q", got)
+	}
+}
+
+func TestAutoDecoderASCIIPassthrough(t *testing.T) {
+	d := newAutoDecoder(936)
+	src := []byte("hdiffz v4.6.3\r\n")
+	dst := make([]byte, 64)
+	nDst, nSrc, err := d.Transform(dst, src, false)
+	if err != nil || nDst != len(src) || nSrc != len(src) || !bytes.Equal(dst[:nDst], src) {
+		t.Fatalf("Transform = %d, %d, %v", nDst, nSrc, err)
+	}
+	if d.mode != decodeDetect {
+		t.Fatalf("只有 ASCII 时不应确定模式，mode = %d", d.mode)
+	}
+
+	// 目标缓冲不够时先输出能放下的 ASCII
+	nDst, nSrc, err = d.Transform(dst[:4], src, false)
+	if err != transform.ErrShortDst || nDst != 4 || nSrc != 4 {
+		t.Fatalf("Transform = %d, %d, %v，应为 4, 4, ErrShortDst", nDst, nSrc, err)
+	}
+
+	// ASCII 前缀原样输出，之后的 GBK 数据按代码页解码
+	src = append([]byte("ok: "), "\xb2\xb9\xb6\xa1"...)
+	nDst, nSrc, err = d.Transform(dst, src, true)
+	if err != nil || nSrc != len(src) || string(dst[:nDst]) != "ok: 补丁" {
+		t.Fatalf("Transform = %q, %d, %v", dst[:nDst], nSrc, err)
+	}
+	if d.mode != decodeCodePage {
+		t.Fatalf("mode = %d，应为 decodeCodePage", d.mode)
+	}
+	d.Reset()
+	if d.mode != decodeDetect {
+		t.Fatalf("Reset 后 mode = %d", d.mode)
+	}
+}
+
+// ASCII 之后紧跟被截断的多字节字符时，先输出 ASCII，剩下的等下一块数据
+func TestAutoDecoderShortSrc(t *testing.T) {
+	d := newAutoDecoder(936)
+	src := []byte("ab\xe8\xa1")
+	dst := make([]byte, 16)
+	nDst, nSrc, err := d.Transform(dst, src, false)
+	if err != transform.ErrShortSrc || nDst != 2 || nSrc != 2 {
+		t.Fatalf("Transform = %d, %d, %v，应为 2, 2, ErrShortSrc", nDst, nSrc, err)
+	}
+	if d.mode != decodeDetect {
+		t.Fatalf("数据不完整时不应确定模式，mode = %d", d.mode)
+	}
+}
+
+// 多字节字符被拆到多次 Read 中，结果与一次解码相同
+func TestAutoDecoderSplitAcrossReads(t *testing.T) {
+	samples := append(codePageSamples[:len(codePageSamples):len(codePageSamples)], struct {
+		name string
+		cp   uintptr
+		raw  []byte
+		want string
+	}{"UTF-8", 936, []byte("正在创建补丁 ファイル\r\n"), "正在创建补丁 ファイル\r\n"})
+	for _, s := range samples {
+		t.Run(s.name, func(t *testing.T) {
+			for _, r := range []io.Reader{
+				iotest.OneByteReader(bytes.NewReader(s.raw)),
+				iotest.HalfReader(bytes.NewReader(s.raw)),
+			} {
+				got, err := io.ReadAll(transform.NewReader(r, newAutoDecoder(s.cp)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != s.want {
+					t.Fatalf("got %q，应为 %q", got, s.want)
+				}
+			}
+		})
+	}
+}
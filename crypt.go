@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lxn/walk"
+	"golang.org/x/crypto/scrypt"
+)
+
+// 加密补丁: 魔数 + 头长度 (uint32 BE) + JSON 头 + 分块的 AES-256-GCM 密文
+// 每块明文 chunkSize 字节，nonce = 前缀(7) + 块序号(4) + 是否最后一块(1)，附加数据为整个头，
+// 块被截断、重排或头被修改都会导致解密失败
+const (
+	encExt       = ".enc"
+	encMagic     = "HDZENC01"
+	encChunkSize = 1 << 20
+	encHKDFInfo  = "hdiffz-gui x25519 key wrap"
+)
+
+// 加密方式
+const (
+	encPassphrase = iota
+	encRecipients
+)
+
+type encHeader struct {
+	KDF        string         `json:"kdf,omitempty"` // scrypt
+	Salt       []byte         `json:"salt,omitempty"`
+	N          int            `json:"n,omitempty"`
+	R          int            `json:"r,omitempty"`
+	P          int            `json:"p,omitempty"`
+	Recipients []encRecipient `json:"recipients,omitempty"`
+	ChunkSize  int            `json:"chunk_size"`
+	Nonce      []byte         `json:"nonce_prefix"`
+}
+
+// 用接收者公钥包装的文件密钥
+type encRecipient struct {
+	KeyID     string `json:"key_id"`
+	Ephemeral []byte `json:"ephemeral"`
+	Wrapped   []byte `json:"wrapped"`
+}
+
+// 加密或解密使用的口令/密钥
+type cryptOptions struct {
+	Mode       int
+	Passphrase string
+	Recipients []*ecdh.PublicKey // 加密
+	Identity   *ecdh.PrivateKey  // 解密
+}
+
+func isEncrypted(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, len(encMagic))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+	return string(buf) == encMagic
+}
+
+// scrypt 参数上限: 头没有认证，伪造的 N/r 会让 scrypt 分配 128*r*N 字节内存
+const (
+	encMaxScryptN   = 1 << 20
+	encMaxScryptR   = 16
+	encMaxScryptP   = 4
+	encMaxScryptMem = 1 << 28
+)
+
+func scryptKey(pass string, h *encHeader) ([]byte, error) {
+	if h.N > encMaxScryptN || h.R <= 0 || h.R > encMaxScryptR || h.P <= 0 || h.P > encMaxScryptP ||
+		128*int64(h.N)*int64(h.R) > encMaxScryptMem {
+		return nil, fmt.Errorf("加密补丁头中的 scrypt 参数超出范围 (N=%d r=%d p=%d)", h.N, h.R, h.P)
+	}
+	return scrypt.Key([]byte(pass), h.Salt, h.N, h.R, h.P, 32)
+}
+
+func x25519KeyID(pub *ecdh.PublicKey) string {
+	sum := sha256.Sum256(pub.Bytes())
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// 包装密钥: HKDF(共享密钥, 临时公钥||接收者公钥)，每个接收者的密钥只用一次，nonce 可以固定为 0
+func wrapKey(shared, eph, recipient []byte) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, shared, append(append([]byte{}, eph...), recipient...), encHKDFInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 生成头和文件密钥
+func newEncHeader(opts cryptOptions) (*encHeader, []byte, error) {
+	h := &encHeader{ChunkSize: encChunkSize, Nonce: make([]byte, 7)}
+	if _, err := rand.Read(h.Nonce); err != nil {
+		return nil, nil, err
+	}
+	if opts.Mode == encPassphrase {
+		if opts.Passphrase == "" {
+			return nil, nil, errors.New("请输入加密口令")
+		}
+		h.KDF, h.N, h.R, h.P = "scrypt", 1<<15, 8, 1
+		h.Salt = make([]byte, 16)
+		if _, err := rand.Read(h.Salt); err != nil {
+			return nil, nil, err
+		}
+		key, err := scryptKey(opts.Passphrase, h)
+		return h, key, err
+	}
+
+	if len(opts.Recipients) == 0 {
+		return nil, nil, errors.New("请选择至少一个接收者公钥")
+	}
+	fileKey := make([]byte, 32)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, nil, err
+	}
+	for _, pub := range opts.Recipients {
+		eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		shared, err := eph.ECDH(pub)
+		if err != nil {
+			return nil, nil, err
+		}
+		aead, err := wrapKey(shared, eph.PublicKey().Bytes(), pub.Bytes())
+		if err != nil {
+			return nil, nil, err
+		}
+		h.Recipients = append(h.Recipients, encRecipient{
+			KeyID:     x25519KeyID(pub),
+			Ephemeral: eph.PublicKey().Bytes(),
+			Wrapped:   aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil),
+		})
+	}
+	return h, fileKey, nil
+}
+
+// 从头中取回文件密钥
+func (h *encHeader) fileKey(opts cryptOptions) ([]byte, error) {
+	if h.KDF == "scrypt" {
+		if opts.Passphrase == "" {
+			return nil, errors.New("补丁已用口令加密，请输入解密口令")
+		}
+		return scryptKey(opts.Passphrase, h)
+	}
+	if h.KDF != "" {
+		return nil, fmt.Errorf("不支持的密钥派生方式: %s", h.KDF)
+	}
+	if opts.Identity == nil {
+		return nil, errors.New("补丁已用接收者公钥加密，请选择解密私钥")
+	}
+	id := x25519KeyID(opts.Identity.PublicKey())
+	for _, r := range h.Recipients {
+		if r.KeyID != id {
+			continue
+		}
+		eph, err := ecdh.X25519().NewPublicKey(r.Ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := opts.Identity.ECDH(eph)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := wrapKey(shared, r.Ephemeral, opts.Identity.PublicKey().Bytes())
+		if err != nil {
+			return nil, err
+		}
+		key, err := aead.Open(nil, make([]byte, aead.NonceSize()), r.Wrapped, nil)
+		if err != nil {
+			return nil, errors.New("无法解开文件密钥，私钥或补丁已损坏")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("补丁的接收者中没有该私钥 (%s)", id)
+}
+
+func chunkNonce(prefix []byte, seq uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:], seq)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// 加密文件
+func encryptFile(src, dst string, opts cryptOptions) error {
+	h, key, err := newEncHeader(opts)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	err = writeEncrypted(w, in, aead, h, header)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeEncrypted(w io.Writer, r io.Reader, aead cipher.AEAD, h *encHeader, header []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(header)))
+	if _, err := w.Write(append(append([]byte(encMagic), size[:]...), header...)); err != nil {
+		return err
+	}
+	// 多读一块以判断当前块是否为最后一块
+	br := bufio.NewReaderSize(r, h.ChunkSize+1)
+	buf := make([]byte, h.ChunkSize)
+	for seq := uint32(0); ; seq++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		_, perr := br.Peek(1)
+		last := perr != nil
+		if _, err := w.Write(aead.Seal(nil, chunkNonce(h.Nonce, seq, last), buf[:n], header)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// 解密文件
+func decryptFile(src, dst string, opts cryptOptions) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	br := bufio.NewReader(in)
+	prefix := make([]byte, len(encMagic)+4)
+	if _, err := io.ReadFull(br, prefix); err != nil || string(prefix[:len(encMagic)]) != encMagic {
+		return errors.New("不是加密补丁")
+	}
+	size := binary.BigEndian.Uint32(prefix[len(encMagic):])
+	if size > 1<<20 {
+		return errors.New("加密补丁头过大")
+	}
+	header := make([]byte, size)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("加密补丁头不完整 - %v", err)
+	}
+	var h encHeader
+	if err := json.Unmarshal(header, &h); err != nil {
+		return fmt.Errorf("加密补丁头格式错误 - %v", err)
+	}
+	if h.ChunkSize <= 0 || h.ChunkSize > 64<<20 || len(h.Nonce) != 7 {
+		return errors.New("加密补丁头格式错误")
+	}
+	key, err := h.fileKey(opts)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	err = readEncrypted(w, br, aead, &h, header)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func readEncrypted(w io.Writer, br *bufio.Reader, aead cipher.AEAD, h *encHeader, header []byte) error {
+	buf := make([]byte, h.ChunkSize+aead.Overhead())
+	for seq := uint32(0); ; seq++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return errors.New("加密补丁不完整")
+		}
+		_, perr := br.Peek(1)
+		last := perr != nil
+		plain, err := aead.Open(nil, chunkNonce(h.Nonce, seq, last), buf[:n], header)
+		if err != nil {
+			if seq == 0 && h.KDF == "scrypt" {
+				return errors.New("解密失败，口令错误或补丁已损坏")
+			}
+			return errors.New("解密失败，补丁已损坏或被截断")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// 生成 X25519 加密密钥对，私钥保存到 privPath，公钥保存到同名 .pub
+func generateEncryptionKey(privPath string) (string, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return "", err
+	}
+	pubPath := strings.TrimSuffix(privPath, filepath.Ext(privPath)) + ".pub"
+	der, err = x509.MarshalPKIXPublicKey(priv.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	return pubPath, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+}
+
+func loadX25519Private(path string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s 不是私钥文件", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s 不是 X25519 私钥", path)
+	}
+	return priv, nil
+}
+
+func loadX25519Public(path string) (*ecdh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s 不是公钥文件", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*ecdh.PublicKey)
+	if !ok || pub.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s 不是 X25519 公钥", path)
+	}
+	return pub, nil
+}
+
+// 生成补丁页的加密设置
+func (mw *AppMainWindow) encryptOptions() (cryptOptions, error) {
+	opts := cryptOptions{Mode: mw.PatchTab.EncryptModeCombo.CurrentIndex()}
+	if opts.Mode == encPassphrase {
+		opts.Passphrase = mw.PatchTab.EncryptPassEdit.Text()
+		if opts.Passphrase == "" {
+			return opts, errors.New("请输入加密口令")
+		}
+		return opts, nil
+	}
+	for _, p := range splitPatchList(mw.PatchTab.EncryptKeysEdit.Text()) {
+		pub, err := loadX25519Public(p)
+		if err != nil {
+			return opts, err
+		}
+		opts.Recipients = append(opts.Recipients, pub)
+	}
+	if len(opts.Recipients) == 0 {
+		return opts, errors.New("请选择至少一个接收者公钥")
+	}
+	return opts, nil
+}
+
+// 应用补丁页的解密设置，未填写时为空
+func (mw *AppMainWindow) decryptOptions() (*cryptOptions, error) {
+	opts := &cryptOptions{Passphrase: mw.ApplyTab.DecryptPassEdit.Text()}
+	if keyPath := cleanPath(mw.ApplyTab.DecryptKeyEdit.Text()); keyPath != "" {
+		priv, err := loadX25519Private(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.Identity = priv
+	}
+	return opts, nil
+}
+
+// 解密到 workDir，并把补丁的清单一起带过去以便按清单校验
+func (j *job) decryptPatch(patch, workDir string) (string, error) {
+	if j.Decrypt == nil {
+		return "", errors.New("补丁已加密，请填写解密口令或私钥")
+	}
+	plainName := strings.TrimSuffix(filepath.Base(patch), encExt)
+	if plainName == filepath.Base(patch) {
+		plainName += ".dec"
+	}
+	dir := filepath.Join(workDir, plainName+".dec")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	out := filepath.Join(dir, plainName)
+	j.Log.Info("解密补丁: " + filepath.Base(patch))
+	if err := decryptFile(patch, out, *j.Decrypt); err != nil {
+		return "", err
+	}
+	if m, _, err := findManifest(filepath.Join(filepath.Dir(patch), plainName)); err == nil {
+		if err := m.Save(manifestPathFor(out)); err != nil {
+			return "", err
+		}
+	}
+	return out, nil
+}
+
+func (mw *AppMainWindow) generateEncryptionKey() {
+	log := mw.PatchTab.Log
+	dlg := new(walk.FileDialog)
+	dlg.Title = "保存解密私钥"
+	dlg.Filter = "私钥 (*.key)|*.key"
+	dlg.FilePath = "hdiffz-decrypt.key"
+	if ok, _ := dlg.ShowSave(mw.MainWindow); !ok || dlg.FilePath == "" {
+		return
+	}
+	privPath := dlg.FilePath
+	if !strings.HasSuffix(strings.ToLower(privPath), ".key") {
+		privPath += ".key"
+	}
+	pubPath, err := generateEncryptionKey(privPath)
+	if err != nil {
+		log.Errorf("生成密钥失败 - %v", err)
+		return
+	}
+	log.Info("解密私钥已保存: " + privPath + " (交给接收方，用于应用补丁时解密)")
+	log.Info("接收者公钥已保存: " + pubPath)
+	keys := splitPatchList(mw.PatchTab.EncryptKeysEdit.Text())
+	mw.PatchTab.EncryptKeysEdit.SetText(joinPatchList(append(keys, pubPath)))
+}
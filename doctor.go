@@ -0,0 +1,322 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lxn/walk"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// 诊断报告中的一项，Problem 表示可能导致任务失败
+type diagItem struct {
+	Name    string
+	Value   string
+	Problem bool
+}
+
+type diagSection struct {
+	Title string
+	Items []diagItem
+}
+
+type diagReport struct {
+	Time     time.Time
+	Sections []diagSection
+}
+
+func (s *diagSection) add(name, value string) {
+	s.Items = append(s.Items, diagItem{Name: name, Value: value})
+}
+
+func (s *diagSection) problem(name, value string) {
+	s.Items = append(s.Items, diagItem{Name: name, Value: value, Problem: true})
+}
+
+func (r *diagReport) Problems() int {
+	n := 0
+	for _, s := range r.Sections {
+		for _, it := range s.Items {
+			if it.Problem {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (r *diagReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "hdiffz-gui 诊断报告  %s\r\n", r.Time.Format("2006-01-02 15:04:05"))
+	for _, s := range r.Sections {
+		fmt.Fprintf(&sb, "\r\n[%s]\r\n", s.Title)
+		for _, it := range s.Items {
+			mark := "  "
+			if it.Problem {
+				mark = "! "
+			}
+			fmt.Fprintf(&sb, "%s%-12s %s\r\n", mark, it.Name+":", it.Value)
+		}
+	}
+	if n := r.Problems(); n > 0 {
+		fmt.Fprintf(&sb, "\r\n发现 %d 个问题 (以 ! 标出)\r\n", n)
+	} else {
+		sb.WriteString("\r\n未发现问题\r\n")
+	}
+	return sb.String()
+}
+
+// 磁盘剩余空间（当前用户可用）和总大小；路径不存在时按所在卷计算
+func diskFree(path string) (free, total uint64, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	dir := filepath.VolumeName(abs) + `\`
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = windows.GetDiskFreeSpaceEx(p, &free, &total, nil)
+	return free, total, err
+}
+
+// 在目录中创建并删除一个临时文件，检查是否可写
+func dirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".hdiffz-doctor-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+func longPathsEnabled() (system, process string) {
+	system = "未知"
+	if k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\FileSystem`, registry.QUERY_VALUE); err == nil {
+		if v, _, err := k.GetIntegerValue("LongPathsEnabled"); err == nil {
+			system = map[bool]string{true: "已启用", false: "未启用"}[v == 1]
+		} else {
+			system = "未启用"
+		}
+		k.Close()
+	}
+	process = "不支持 (Windows 10 1607 之前)"
+	proc := syscall.NewLazyDLL("ntdll.dll").NewProc("RtlAreLongPathsEnabled")
+	if proc.Find() == nil {
+		r, _, _ := proc.Call()
+		process = map[bool]string{true: "已启用", false: "未启用"}[byte(r) != 0]
+	}
+	return
+}
+
+func diagDir(s *diagSection, name, dir string) {
+	if dir == "" {
+		s.problem(name, "无法获取")
+		return
+	}
+	if err := dirWritable(dir); err != nil {
+		s.problem(name, fmt.Sprintf("%s (不可写: %v)", dir, err))
+		return
+	}
+	s.add(name, dir+" (可写)")
+}
+
+// 收集诊断信息，paths 为要检查剩余空间的输入/输出路径
+func runDiagnostics(paths []string) *diagReport {
+	r := &diagReport{Time: time.Now()}
+
+	app := diagSection{Title: "程序"}
+	exe, _ := os.Executable()
+	app.add("程序路径", exe)
+	v := windows.RtlGetVersion()
+	app.add("系统", fmt.Sprintf("Windows %d.%d.%d (%s/%s)", v.MajorVersion, v.MinorVersion, v.BuildNumber, runtime.GOOS, runtime.GOARCH))
+	app.add("Go", runtime.Version())
+	r.Sections = append(r.Sections, app)
+
+	tools := diagSection{Title: "工具"}
+	for _, name := range []string{"hdiffz.exe", "hpatchz.exe"} {
+		path, err := findTool(name)
+		if err != nil {
+			tools.problem(name, "未找到 (应与本程序放在同一目录)")
+			continue
+		}
+		caps, err := toolCapabilities(path)
+		if err != nil {
+			tools.problem(name, fmt.Sprintf("%s (无法运行: %v)", path, err))
+			continue
+		}
+		tools.add(name, fmt.Sprintf("%s  %s", path, caps))
+		tools.add("  参数", strings.Join(caps.Options, " "))
+		tools.add("  压缩", strings.Join(caps.Compressors, " "))
+	}
+	r.Sections = append(r.Sections, tools)
+
+	enc := diagSection{Title: "编码"}
+	name := "UTF-8 / 不转换"
+	if e := encodingForCodePage(Cp); e != nil {
+		name = fmt.Sprint(e)
+	}
+	enc.add("代码页 (Cp)", fmt.Sprintf("%d (%s)", Cp, name))
+	r.Sections = append(r.Sections, enc)
+
+	dirs := diagSection{Title: "目录"}
+	wd, _ := os.Getwd()
+	diagDir(&dirs, "工作目录", wd)
+	diagDir(&dirs, "程序目录", filepath.Dir(exe))
+	diagDir(&dirs, "临时目录", os.TempDir())
+	if dir, err := appDataDir(); err != nil {
+		dirs.problem("数据目录", err.Error())
+	} else {
+		diagDir(&dirs, "数据目录", dir)
+	}
+	if p, err := settingsPath(); err == nil {
+		state := "不存在，使用默认设置"
+		if _, err := loadSettings(); err != nil {
+			dirs.problem("设置文件", fmt.Sprintf("%s (读取失败: %v)", p, err))
+		} else {
+			if getPathType(p) == FileTypeFile {
+				state = "已存在"
+			}
+			dirs.add("设置文件", fmt.Sprintf("%s (%s)", p, state))
+		}
+	}
+	if dir, err := logDir(); err == nil {
+		dirs.add("日志目录", dir)
+	}
+	r.Sections = append(r.Sections, dirs)
+
+	lp := diagSection{Title: "长路径"}
+	system, process := longPathsEnabled()
+	lp.add("系统设置", system+" (LongPathsEnabled)")
+	if process == "已启用" {
+		lp.add("当前进程", process)
+	} else {
+		lp.problem("当前进程", process+"，超过 260 个字符的路径可能失败")
+	}
+	r.Sections = append(r.Sections, lp)
+
+	disk := diagSection{Title: "磁盘空间"}
+	seen := map[string]bool{}
+	for _, p := range append([]string{os.TempDir()}, paths...) {
+		if p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		vol := strings.ToUpper(filepath.VolumeName(abs))
+		if seen[vol] {
+			continue
+		}
+		seen[vol] = true
+		free, total, err := diskFree(abs)
+		switch {
+		case err != nil:
+			disk.problem(vol, fmt.Sprintf("无法获取 (%v)", err))
+		case free < 1<<30:
+			disk.problem(vol, fmt.Sprintf("剩余 %s / 共 %s (剩余空间不足 1 GB)", formatSize(int64(free)), formatSize(int64(total))))
+		default:
+			disk.add(vol, fmt.Sprintf("剩余 %s / 共 %s", formatSize(int64(free)), formatSize(int64(total))))
+		}
+	}
+	r.Sections = append(r.Sections, disk)
+	return r
+}
+
+// 命令行: hdiffz-gui.exe doctor [-o 报告文件] [要检查磁盘空间的路径...]
+// 返回值作为退出码，发现问题时为 1
+func doctorCLI(args []string) int {
+	attachConsole()
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	out := fs.String("o", "", "把报告保存到文件")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	r := runDiagnostics(fs.Args())
+	text := r.String()
+	if *out != "" {
+		if err := os.WriteFile(*out, []byte(text), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "保存报告失败 - %v\n", err)
+			return 2
+		}
+	}
+	// 按控制台的代码页输出
+	cp := Cp
+	if c, err := windows.GetConsoleOutputCP(); err == nil && c != 0 {
+		cp = uintptr(c)
+	}
+	if e := encodingForCodePage(cp); e != nil {
+		if s, err := e.NewEncoder().String(text); err == nil {
+			text = s
+		}
+	}
+	fmt.Print(text)
+	if r.Problems() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// 以 -H=windowsgui 编译时没有控制台，从命令行启动时附加到父进程的控制台
+func attachConsole() {
+	const attachParentProcess = ^uint32(0)
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("AttachConsole")
+	if r, _, _ := proc.Call(uintptr(attachParentProcess)); r == 0 {
+		return
+	}
+	if f, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0); err == nil {
+		os.Stdout = f
+		os.Stderr = f
+	}
+}
+
+// 诊断页: 检查界面中已填写的路径所在磁盘
+func (mw *AppMainWindow) refreshDiagnostics() {
+	paths := []string{
+		mw.PatchTab.OldPathEdit.Text(), mw.PatchTab.NewPathEdit.Text(), mw.PatchTab.OutPutEdit.Text(),
+		mw.ApplyTab.OldPathEdit.Text(), mw.ApplyTab.OutPutEdit.Text(),
+	}
+	mw.DoctorTab.ReportEdit.SetText("正在检测...")
+	mw.DoctorTab.RefreshBtn.SetEnabled(false)
+	go func() {
+		r := runDiagnostics(paths)
+		mw.Synchronize(func() {
+			mw.DoctorTab.Report = r
+			mw.DoctorTab.ReportEdit.SetText(r.String())
+			mw.DoctorTab.RefreshBtn.SetEnabled(true)
+		})
+	}()
+}
+
+func (mw *AppMainWindow) exportDiagnostics() {
+	r := mw.DoctorTab.Report
+	if r == nil {
+		return
+	}
+	dlg := new(walk.FileDialog)
+	dlg.Title = "导出诊断报告"
+	dlg.Filter = "文本文件 (*.txt)|*.txt|所有文件 (*.*)|*.*"
+	dlg.FilePath = "hdiffz-gui-doctor.txt"
+	if ok, _ := dlg.ShowSave(mw.MainWindow); !ok || dlg.FilePath == "" {
+		return
+	}
+	if err := os.WriteFile(dlg.FilePath, []byte(r.String()), 0644); err != nil {
+		walk.MsgBox(mw.MainWindow, "导出失败", err.Error(), walk.MsgBoxIconError)
+	}
+}
+
+func (mw *AppMainWindow) copyDiagnostics() {
+	if r := mw.DoctorTab.Report; r != nil {
+		_ = walk.Clipboard().SetText(r.String())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import "fmt"
+
+// 补丁输出格式
+type patchFormat struct {
+	Name     string
+	Flag     string // hdiffz 参数，原生格式为空
+	Ext      string
+	Compress string // 勾选压缩时使用的压缩参数，为空表示该格式不支持 -c
+	Forced   string // 该格式必须使用的压缩参数
+	Dir      bool   // 是否支持文件夹
+}
+
+var patchFormats = []patchFormat{
+	{Name: "HDiffPatch (默认)", Ext: defaultPatchExt, Compress: "-c-zstd-21-24", Dir: true},
+	{Name: "单压缩流 (-SD)", Flag: "-SD", Ext: ".sdiff", Compress: "-c-zstd-21-24"},
+	{Name: "bsdiff4 (-BSD)", Flag: "-BSD", Ext: ".bsdiff", Forced: "-c-bzip2-9"},
+	{Name: "VCDIFF (-VCD)", Flag: "-VCD", Ext: ".vcdiff"},
+}
+
+// 匹配模式
+var matchModes = []struct {
+	Name string
+	Flag string
+}{
+	{"内存匹配 (-m)", ""},
+	{"流式匹配 (-s)", "-s"},
+}
+
+func formatNames() []string {
+	names := make([]string, len(patchFormats))
+	for i, f := range patchFormats {
+		names[i] = f.Name
+	}
+	return names
+}
+
+func matchModeNames() []string {
+	names := make([]string, len(matchModes))
+	for i, m := range matchModes {
+		names[i] = m.Name
+	}
+	return names
+}
+
+func formatIndex(flag string) int {
+	for i, f := range patchFormats {
+		if f.Flag == flag {
+			return i
+		}
+	}
+	return 0
+}
+
+func patchFormatAt(i int) patchFormat {
+	if i < 0 || i >= len(patchFormats) {
+		return patchFormats[0]
+	}
+	return patchFormats[i]
+}
+
+// 检查输出格式与压缩选项、输入类型的组合，返回对应的 hdiffz 参数
+func (f patchFormat) Args(compress bool, inputType FileType, matchMode int) ([]string, error) {
+	if inputType == FileTypeDirectory && !f.Dir {
+		return nil, fmt.Errorf("%s 格式不支持文件夹，请使用 HDiffPatch 默认格式", f.Name)
+	}
+	if matchMode < 0 || matchMode >= len(matchModes) {
+		matchMode = 0
+	}
+	var args []string
+	if flag := matchModes[matchMode].Flag; flag != "" {
+		args = append(args, flag)
+	}
+	if f.Flag != "" {
+		args = append(args, f.Flag)
+	}
+	switch {
+	case f.Forced != "":
+		args = append(args, f.Forced)
+	case compress && f.Compress == "":
+		return nil, fmt.Errorf("%s 格式不支持 -c 压缩，请取消压缩选项", f.Name)
+	case compress:
+		args = append(args, f.Compress)
+	}
+	return args, nil
+}
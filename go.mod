@@ -2,10 +2,16 @@ module hdiff-gui
 
 go 1.25.5
 
-require github.com/lxn/walk v0.0.0-20210112085537-c389da54e794
+require (
+	github.com/dsnet/compress v0.0.1
+	github.com/klauspost/compress v1.18.0
+	github.com/lxn/walk v0.0.0-20210112085537-c389da54e794
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/crypto v0.50.0
+)
 
 require (
-	golang.org/x/sys v0.43.0 // indirect
+	golang.org/x/sys v0.43.0
 	golang.org/x/text v0.37.0
 )
 
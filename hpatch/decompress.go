@@ -0,0 +1,104 @@
+package hpatch
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// 支持的压缩类型 (hdiffz 的 -c- 参数)
+var Compressors = []string{"zstd", "zlib", "pzlib", "lzma", "lzma2", "bzip2", "bz2", "pbzip2", "pbz2"}
+
+func supported(compress string) bool {
+	for _, c := range Compressors {
+		if c == compress {
+			return true
+		}
+	}
+	return false
+}
+
+// 按 hdiffz 压缩插件的格式打开解压流，size 为解压后的大小
+func decompressor(compress string, r io.Reader, size uint64) (io.ReadCloser, error) {
+	switch compress {
+	case "zstd":
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxDictSize))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case "zlib", "pzlib":
+		// 首字节为 windowBits，负数表示裸 deflate 流
+		var wb [1]byte
+		if _, err := io.ReadFull(r, wb[:]); err != nil {
+			return nil, unexpected(err)
+		}
+		if int8(wb[0]) < 0 {
+			return flate.NewReader(r), nil
+		}
+		return zlib.NewReader(r)
+	case "lzma":
+		// 首字节为属性长度，随后是 5 字节属性；补上解压大小拼成 .lzma 头
+		var props [6]byte
+		if _, err := io.ReadFull(r, props[:]); err != nil {
+			return nil, unexpected(err)
+		}
+		if props[0] != 5 {
+			return nil, fmt.Errorf("lzma 属性长度错误: %d", props[0])
+		}
+		dict, err := limitDict(uint64(binary.LittleEndian.Uint32(props[2:])), size)
+		if err != nil {
+			return nil, err
+		}
+		head := make([]byte, 13)
+		head[0] = props[1]
+		binary.LittleEndian.PutUint32(head[1:], uint32(dict))
+		binary.LittleEndian.PutUint64(head[5:], size)
+		lr, err := lzma.NewReader(io.MultiReader(bytes.NewReader(head), r))
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(lr), nil
+	case "lzma2":
+		// 首字节为字典大小的编码
+		var p [1]byte
+		if _, err := io.ReadFull(r, p[:]); err != nil {
+			return nil, unexpected(err)
+		}
+		if p[0] > 40 {
+			return nil, fmt.Errorf("lzma2 字典大小错误: %d", p[0])
+		}
+		dict := uint64(0xFFFFFFFF)
+		if p[0] < 40 {
+			dict = uint64(2|p[0]&1) << (p[0]/2 + 11)
+		}
+		dict, err := limitDict(dict, size)
+		if err != nil {
+			return nil, err
+		}
+		cfg := lzma.Reader2Config{DictCap: int(dict)}
+		lr, err := cfg.NewReader2(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(lr), nil
+	case "bzip2", "bz2", "pbzip2", "pbz2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("%w: 压缩类型 %s", ErrUnsupported, compress)
+}
+
+// 检查文件头中的字典大小；字典超过解压后的大小没有意义，按实际需要分配
+func limitDict(dict, size uint64) (uint64, error) {
+	if dict > maxDictSize {
+		return 0, fmt.Errorf("%w: 字典大小 %d 超过上限", ErrCorrupt, dict)
+	}
+	return max(min(dict, size), lzma.MinDictCap), nil
+}
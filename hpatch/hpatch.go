@@ -0,0 +1,224 @@
+// Package hpatch 用纯 Go 应用 HDiffPatch 的单文件补丁 (HDIFF13 / HDIFFSF20)，不依赖 hpatchz
+//
+// 支持未压缩以及 zstd / zlib / lzma / lzma2 / bzip2 压缩的补丁，不支持文件夹补丁 (HDIFF19)
+package hpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	ErrUnsupported = errors.New("不支持的补丁")
+	ErrCorrupt     = errors.New("补丁已损坏")
+)
+
+// 文件头中的大小决定要分配的内存，超过这些上限视为补丁损坏，避免按伪造的大小分配内存
+const (
+	// hdiffz -SD-stepSize 的分步缓冲
+	maxStepMemSize = 1 << 28
+	// lzma / lzma2 / zstd 的字典（窗口）大小，hdiffz 最大为 1<<30
+	maxDictSize = 1 << 30
+)
+
+const (
+	FormatHDIFF13   = "HDIFF13"
+	FormatHDIFFSF20 = "HDIFFSF20"
+)
+
+// 补丁中的一段数据，CompressedSize 为 0 表示未压缩
+type section struct {
+	pos            int64
+	size           uint64
+	compressedSize uint64
+}
+
+func (s section) stored() uint64 {
+	if s.compressedSize > 0 {
+		return s.compressedSize
+	}
+	return s.size
+}
+
+// 补丁文件头
+type Header struct {
+	Format   string
+	Compress string // 未压缩为空
+	NewSize  uint64
+	OldSize  uint64
+
+	coverCount uint64
+	// HDIFF13: 覆盖区、RLE 控制、RLE 数据、新增数据四段
+	covers, rleCtrl, rleCode, newData section
+	// HDIFFSF20: 按步组织的单个数据流
+	stepMemSize uint64
+	data        section
+}
+
+// 记录读取位置，用来计算各段数据在补丁中的偏移
+type countingReader struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.pos++
+	}
+	return b, err
+}
+
+// 读到 end 为止的类型字符串
+func (c *countingReader) readType(end byte) (string, error) {
+	var sb bytes.Buffer
+	for sb.Len() < 64 {
+		b, err := c.ReadByte()
+		if err != nil {
+			return "", unexpected(err)
+		}
+		if b == end {
+			return sb.String(), nil
+		}
+		sb.WriteByte(b)
+	}
+	return "", errors.New("无法识别的补丁格式")
+}
+
+func (c *countingReader) sizes(vals ...*uint64) error {
+	for _, v := range vals {
+		var err error
+		if *v, err = readSize(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 读取并检查补丁文件头
+func ReadHeader(diff io.ReaderAt, diffSize int64) (*Header, error) {
+	c := &countingReader{r: bufio.NewReader(io.NewSectionReader(diff, 0, diffSize))}
+	format, err := c.readType('&')
+	if err != nil {
+		return nil, err
+	}
+	h := &Header{Format: format}
+	if h.Compress, err = c.readType(0); err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatHDIFF13:
+		err = c.sizes(&h.NewSize, &h.OldSize, &h.coverCount,
+			&h.covers.size, &h.covers.compressedSize,
+			&h.rleCtrl.size, &h.rleCtrl.compressedSize,
+			&h.rleCode.size, &h.rleCode.compressedSize,
+			&h.newData.size, &h.newData.compressedSize)
+		pos := c.pos
+		for _, s := range []*section{&h.covers, &h.rleCtrl, &h.rleCode, &h.newData} {
+			s.pos = pos
+			pos += int64(s.stored())
+		}
+		if err == nil && (pos < c.pos || pos > diffSize) {
+			err = io.ErrUnexpectedEOF
+		}
+	case FormatHDIFFSF20:
+		err = c.sizes(&h.NewSize, &h.OldSize, &h.coverCount, &h.stepMemSize, &h.data.size, &h.data.compressedSize)
+		h.data.pos = c.pos
+		if err == nil && (h.data.stored() > uint64(diffSize-c.pos)) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil && h.stepMemSize > maxStepMemSize {
+			return nil, fmt.Errorf("%w: 分步缓冲大小 %d 超过上限", ErrCorrupt, h.stepMemSize)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("补丁文件头错误 - %v", err)
+	}
+	if h.Compress != "" && !supported(h.Compress) {
+		return nil, fmt.Errorf("%w: 压缩类型 %s", ErrUnsupported, h.Compress)
+	}
+	return h, nil
+}
+
+// 打开一段数据，压缩的数据边读边解压
+func (h *Header) open(diff io.ReaderAt, s section) (*bufio.Reader, func() error, error) {
+	raw := io.NewSectionReader(diff, s.pos, int64(s.stored()))
+	if s.compressedSize == 0 {
+		return bufio.NewReader(raw), func() error { return nil }, nil
+	}
+	if h.Compress == "" {
+		return nil, nil, errors.New("补丁中有压缩数据但没有压缩类型")
+	}
+	rc, err := decompressor(h.Compress, raw, s.size)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bufio.NewReader(io.LimitReader(rc, int64(s.size))), rc.Close, nil
+}
+
+// 用补丁 diff 把旧数据 old 更新为新数据，写入 out
+func Apply(old io.ReaderAt, oldSize int64, diff io.ReaderAt, diffSize int64, out io.Writer) error {
+	h, err := ReadHeader(diff, diffSize)
+	if err != nil {
+		return err
+	}
+	if uint64(oldSize) != h.OldSize {
+		return fmt.Errorf("旧文件大小不匹配 (补丁需要 %d，实际 %d)", h.OldSize, oldSize)
+	}
+	p := &patcher{old: old, oldSize: h.OldSize, newSize: h.NewSize, w: bufio.NewWriterSize(out, 1<<16)}
+	switch h.Format {
+	case FormatHDIFF13:
+		err = p.applyHDIFF13(h, diff)
+	default:
+		err = p.applySingle(h, diff)
+	}
+	if err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+// 按文件路径应用补丁，oldPath 为空表示旧数据为空
+func ApplyFile(oldPath, diffPath, outPath string) error {
+	var old io.ReaderAt = bytes.NewReader(nil)
+	var oldSize int64
+	if oldPath != "" {
+		f, err := os.Open(oldPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		old, oldSize = f, info.Size()
+	}
+	diff, err := os.Open(diffPath)
+	if err != nil {
+		return err
+	}
+	defer diff.Close()
+	info, err := diff.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	err = Apply(old, oldSize, diff, info.Size(), out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(outPath)
+	}
+	return err
+}
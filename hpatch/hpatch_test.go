@@ -0,0 +1,426 @@
+package hpatch
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// 按 readUint 的格式编码变长整数
+func packUint(tag byte, tagBits uint, v uint64) []byte {
+	var rest []byte
+	for v >= 1<<(7-tagBits) {
+		rest = append([]byte{byte(v & 0x7f)}, rest...)
+		v >>= 7
+	}
+	for i := 0; i+1 < len(rest); i++ {
+		rest[i] |= 0x80
+	}
+	first := tag<<(8-tagBits) | byte(v)
+	if len(rest) > 0 {
+		first |= 1 << (7 - tagBits)
+	}
+	return append([]byte{first}, rest...)
+}
+
+func packSize(vals ...uint64) []byte {
+	var b []byte
+	for _, v := range vals {
+		b = append(b, packUint(0, 0, v)...)
+	}
+	return b
+}
+
+// 测试用的补丁内容: 覆盖区和对应的 RLE 差值、新增数据
+type testDiff struct {
+	covers  []cover
+	cover   []byte // 覆盖区编码
+	ctrl    []byte // RLE 控制
+	code    []byte // RLE 数据
+	newData []byte // 覆盖区之间的新增数据
+	gaps    [][]byte
+	tail    []byte
+}
+
+// 按给定覆盖区计算差值，覆盖区必须按新位置递增且不重叠
+func makeDiff(old, new []byte, covers []cover) *testDiff {
+	d := &testDiff{covers: covers}
+	var lastOld, lastNew uint64
+	var delta []byte
+	for _, cv := range covers {
+		if cv.oldPos >= lastOld {
+			d.cover = append(d.cover, packUint(0, 1, cv.oldPos-lastOld)...)
+		} else {
+			d.cover = append(d.cover, packUint(1, 1, lastOld-cv.oldPos)...)
+		}
+		d.cover = append(d.cover, packSize(cv.newPos-lastNew, cv.length)...)
+		gap := new[lastNew:cv.newPos]
+		d.gaps = append(d.gaps, gap)
+		d.newData = append(d.newData, gap...)
+		for i := uint64(0); i < cv.length; i++ {
+			delta = append(delta, new[cv.newPos+i]-old[cv.oldPos+i])
+		}
+		lastOld, lastNew = cv.oldPos+cv.length, cv.newPos+cv.length
+	}
+	d.tail = new[lastNew:]
+	d.newData = append(d.newData, d.tail...)
+	d.ctrl, d.code = packRLE(delta)
+	return d
+}
+
+// 连续的 0、255 和相同字节分别用对应的 RLE 类型，其余按原样存放
+func packRLE(delta []byte) (ctrl, code []byte) {
+	for i := 0; i < len(delta); {
+		j := i + 1
+		for j < len(delta) && delta[j] == delta[i] {
+			j++
+		}
+		switch n := uint64(j - i); {
+		case n < 3:
+			k := i
+			for k < len(delta) && (k+2 >= len(delta) || delta[k] != delta[k+1] || delta[k] != delta[k+2]) {
+				k++
+			}
+			ctrl = append(ctrl, packUint(rleRaw, 2, uint64(k-i-1))...)
+			code = append(code, delta[i:k]...)
+			j = k
+		case delta[i] == 0:
+			ctrl = append(ctrl, packUint(rleZero, 2, n-1)...)
+		case delta[i] == 255:
+			ctrl = append(ctrl, packUint(rle255, 2, n-1)...)
+		default:
+			ctrl = append(ctrl, packUint(rleValue, 2, n-1)...)
+			code = append(code, delta[i])
+		}
+		i = j
+	}
+	return ctrl, code
+}
+
+// 按 hdiffz 压缩插件的格式压缩一段数据
+func compressTest(t *testing.T, compress string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch compress {
+	case "zstd":
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+	case "zlib":
+		// 裸 deflate 流，windowBits 为 -15
+		buf.WriteByte(byte(0xf1))
+		w, _ := flate.NewWriter(&buf, flate.BestCompression)
+		w.Write(data)
+		w.Close()
+	case "pzlib":
+		buf.WriteByte(15)
+		w := zlib.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+	case "lzma":
+		var raw bytes.Buffer
+		w, err := lzma.WriterConfig{Size: int64(len(data)), SizeInHeader: true}.NewWriter(&raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+		// 去掉 .lzma 头中的解压大小
+		buf.WriteByte(5)
+		buf.Write(raw.Bytes()[:5])
+		buf.Write(raw.Bytes()[13:])
+	default:
+		t.Fatalf("未知的压缩类型 %s", compress)
+	}
+	return buf.Bytes()
+}
+
+// 一段数据的大小和存放的内容，空数据不压缩
+func sectionTest(t *testing.T, compress string, data []byte) (size, compressed uint64, stored []byte) {
+	if compress == "" || len(data) == 0 {
+		return uint64(len(data)), 0, data
+	}
+	stored = compressTest(t, compress, data)
+	return uint64(len(data)), uint64(len(stored)), stored
+}
+
+func encodeHDIFF13(t *testing.T, compress string, old, new []byte, d *testDiff) []byte {
+	t.Helper()
+	out := []byte(FormatHDIFF13 + "&" + compress + "\x00")
+	out = append(out, packSize(uint64(len(new)), uint64(len(old)), uint64(len(d.covers)))...)
+	var body []byte
+	for _, data := range [][]byte{d.cover, d.ctrl, d.code, d.newData} {
+		size, compressed, stored := sectionTest(t, compress, data)
+		out = append(out, packSize(size, compressed)...)
+		body = append(body, stored...)
+	}
+	return append(out, body...)
+}
+
+// HDIFFSF20: 每步最多 perStep 个覆盖区，各步之后跟着这些覆盖区之前的新增数据
+func encodeSingle(t *testing.T, compress string, old, new []byte, d *testDiff, perStep int) []byte {
+	t.Helper()
+	var stream []byte
+	var stepMem uint64
+	var lastOld, lastNew uint64
+	delta := make([]byte, 0)
+	for i := 0; i < len(d.covers); i += perStep {
+		var coverBuf []byte
+		delta = delta[:0]
+		end := min(i+perStep, len(d.covers))
+		for _, cv := range d.covers[i:end] {
+			if cv.oldPos >= lastOld {
+				coverBuf = append(coverBuf, packUint(0, 1, cv.oldPos-lastOld)...)
+			} else {
+				coverBuf = append(coverBuf, packUint(1, 1, lastOld-cv.oldPos)...)
+			}
+			coverBuf = append(coverBuf, packSize(cv.newPos-lastNew, cv.length)...)
+			for k := uint64(0); k < cv.length; k++ {
+				delta = append(delta, new[cv.newPos+k]-old[cv.oldPos+k])
+			}
+			lastOld, lastNew = cv.oldPos+cv.length, cv.newPos+cv.length
+		}
+		ctrl, code := packRLE(delta)
+		rleBuf := append(packSize(uint64(len(ctrl))), ctrl...)
+		rleBuf = append(rleBuf, code...)
+		stream = append(stream, packSize(uint64(len(coverBuf)), uint64(len(rleBuf)))...)
+		stream = append(stream, coverBuf...)
+		stream = append(stream, rleBuf...)
+		stepMem = max(stepMem, uint64(len(coverBuf)+len(rleBuf)))
+		for _, gap := range d.gaps[i:end] {
+			stream = append(stream, gap...)
+		}
+	}
+	stream = append(stream, d.tail...)
+
+	out := []byte(FormatHDIFFSF20 + "&" + compress + "\x00")
+	size, compressed, stored := sectionTest(t, compress, stream)
+	out = append(out, packSize(uint64(len(new)), uint64(len(old)), uint64(len(d.covers)), stepMem, size, compressed)...)
+	return append(out, stored...)
+}
+
+// 旧数据的若干片段被移动、修改后组成新数据，片段之间插入新增数据
+func testData() (old, new []byte, covers []cover) {
+	rng := rand.New(rand.NewSource(1))
+	old = make([]byte, 64<<10)
+	rng.Read(old)
+	for _, seg := range []struct{ oldPos, length int }{
+		{40000, 3000}, {100, 9000}, {20000, 5000}, {9100, 7000}, {60000, 4000},
+	} {
+		insert := make([]byte, rng.Intn(500))
+		rng.Read(insert)
+		new = append(new, insert...)
+		covers = append(covers, cover{oldPos: uint64(seg.oldPos), newPos: uint64(len(new)), length: uint64(seg.length)})
+		part := append([]byte{}, old[seg.oldPos:seg.oldPos+seg.length]...)
+		// 零散修改、连续加 1 和连续减 1 分别对应不同的 RLE 类型
+		for k := 0; k < len(part); k += 97 {
+			part[k] ^= 0x5a
+		}
+		for k := 500; k < 800 && k < len(part); k++ {
+			part[k]++
+		}
+		for k := 1000; k < 1200 && k < len(part); k++ {
+			part[k]--
+		}
+		new = append(new, part...)
+	}
+	new = append(new, []byte("tail data")...)
+	return old, new, covers
+}
+
+func applyBytes(old, diff []byte) ([]byte, error) {
+	var out bytes.Buffer
+	err := Apply(bytes.NewReader(old), int64(len(old)), bytes.NewReader(diff), int64(len(diff)), &out)
+	return out.Bytes(), err
+}
+
+func TestApply(t *testing.T) {
+	old, new, covers := testData()
+	d := makeDiff(old, new, covers)
+	for _, compress := range []string{"", "zstd", "zlib", "pzlib", "lzma"} {
+		for _, format := range []string{FormatHDIFF13, FormatHDIFFSF20} {
+			t.Run(format+"&"+compress, func(t *testing.T) {
+				var diff []byte
+				if format == FormatHDIFF13 {
+					diff = encodeHDIFF13(t, compress, old, new, d)
+				} else {
+					diff = encodeSingle(t, compress, old, new, d, 2)
+				}
+				h, err := ReadHeader(bytes.NewReader(diff), int64(len(diff)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if h.Format != format || h.Compress != compress || h.NewSize != uint64(len(new)) || h.OldSize != uint64(len(old)) {
+					t.Fatalf("文件头错误: %+v", h)
+				}
+				got, err := applyBytes(old, diff)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, new) {
+					t.Fatalf("输出与新数据不一致 (%d / %d 字节)", len(got), len(new))
+				}
+			})
+		}
+	}
+}
+
+// 没有覆盖区时新数据全部来自补丁，旧数据可以为空
+func TestApplyNoCovers(t *testing.T) {
+	new := []byte("all new data")
+	d := makeDiff(nil, new, nil)
+	for _, diff := range [][]byte{encodeHDIFF13(t, "", nil, new, d), encodeSingle(t, "", nil, new, d, 1)} {
+		got, err := applyBytes(nil, diff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, new) {
+			t.Fatalf("got %q", got)
+		}
+	}
+}
+
+func TestApplyWrongOldSize(t *testing.T) {
+	old, new, covers := testData()
+	diff := encodeHDIFF13(t, "", old, new, makeDiff(old, new, covers))
+	if _, err := applyBytes(old[1:], diff); err == nil {
+		t.Fatal("旧文件大小不匹配时应返回错误")
+	}
+}
+
+// 截断的补丁不能 panic，只能返回错误
+func TestApplyTruncated(t *testing.T) {
+	old, new, covers := testData()
+	d := makeDiff(old, new, covers)
+	for _, diff := range [][]byte{encodeHDIFF13(t, "zstd", old, new, d), encodeSingle(t, "", old, new, d, 3)} {
+		for _, n := range []int{5, 20, len(diff) / 2, len(diff) - 1} {
+			if _, err := applyBytes(old, diff[:n]); err == nil {
+				t.Fatalf("截断到 %d 字节的补丁应返回错误", n)
+			}
+		}
+	}
+}
+
+func TestStepMemSizeLimit(t *testing.T) {
+	diff := []byte(FormatHDIFFSF20 + "&\x00")
+	diff = append(diff, packSize(1, 0, 1, 1<<40, 4, 0)...)
+	diff = append(diff, packSize(1<<39, 1<<39)...)
+	_, err := ReadHeader(bytes.NewReader(diff), int64(len(diff)))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("err = %v，应为 ErrCorrupt", err)
+	}
+
+	// 文件头合法，但某一步的大小超过 stepMemSize
+	diff = []byte(FormatHDIFFSF20 + "&\x00")
+	step := packSize(1<<20, 1<<20)
+	diff = append(diff, packSize(1, 0, 1, 64, uint64(len(step)), 0)...)
+	diff = append(diff, step...)
+	if _, err := applyBytes(nil, diff); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("err = %v，应为 ErrCorrupt", err)
+	}
+}
+
+func TestDictLimit(t *testing.T) {
+	for _, props := range [][]byte{
+		{5, 0x5d, 0, 0, 0, 0x80}, // lzma 字典 2GB
+		{5, 0x5d, 0xff, 0xff, 0xff, 0xff},
+	} {
+		_, err := decompressor("lzma", bytes.NewReader(props), 100)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("err = %v，应为 ErrCorrupt", err)
+		}
+	}
+	// lzma2 字典编码 40 表示 4GB
+	if _, err := decompressor("lzma2", bytes.NewReader([]byte{40}), 100); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("err = %v，应为 ErrCorrupt", err)
+	}
+	if dict, err := limitDict(64<<20, 1000); err != nil || dict != lzma.MinDictCap {
+		t.Fatalf("limitDict = %d, %v", dict, err)
+	}
+}
+
+func TestCoverReader(t *testing.T) {
+	var b []byte
+	b = append(b, packUint(0, 1, 10)...)
+	b = append(b, packSize(5, 100)...)
+	// 向回跳到旧位置 60
+	b = append(b, packUint(1, 1, 50)...)
+	b = append(b, packSize(0, 300)...)
+	b = append(b, packUint(1, 1, 1000)...)
+	b = append(b, packSize(0, 1)...)
+	c := &coverReader{r: bytes.NewReader(b)}
+	want := []cover{{10, 5, 100}, {60, 105, 300}}
+	for _, w := range want {
+		cv, err := c.next()
+		if err != nil || cv != w {
+			t.Fatalf("next() = %+v, %v，应为 %+v", cv, err, w)
+		}
+	}
+	// 旧位置不能小于 0
+	if _, err := c.next(); err != errCover {
+		t.Fatalf("err = %v，应为 errCover", err)
+	}
+}
+
+func TestRLEAcrossBuffers(t *testing.T) {
+	delta := bytes.Repeat([]byte{0}, 10)
+	delta = append(delta, bytes.Repeat([]byte{255}, 7)...)
+	delta = append(delta, bytes.Repeat([]byte{3}, 9)...)
+	delta = append(delta, 1, 2, 4, 8, 16)
+	ctrl, code := packRLE(delta)
+	d := &rleDecoder{ctrl: bytes.NewReader(ctrl), code: bytes.NewReader(code)}
+	buf := make([]byte, len(delta))
+	// 每次只解一小段，运行长度要跨越多次调用
+	for i := 0; i < len(buf); i += 4 {
+		if err := d.add(buf[i:min(i+4, len(buf))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buf, delta) {
+		t.Fatalf("got %v，应为 %v", buf, delta)
+	}
+	if err := d.add(make([]byte, 1)); err != errRLE {
+		t.Fatalf("RLE 数据用完后 err = %v，应为 errRLE", err)
+	}
+}
+
+// testdata/gen.cmd 用 hdiffz 生成并经 hpatchz 验证过的补丁，应用结果必须与 sample.new 逐字节一致
+func TestApplyFixtures(t *testing.T) {
+	old, err := os.ReadFile(filepath.Join("testdata", "sample.old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "sample.new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, compress := range []string{"raw", "zstd", "zlib", "lzma"} {
+		for _, format := range []string{"hdiff13", "sf20"} {
+			name := "sample." + compress + "." + format + ".hdiff"
+			t.Run(name, func(t *testing.T) {
+				diff, err := os.ReadFile(filepath.Join("testdata", name))
+				if err != nil {
+					t.Fatalf("缺少 hdiffz 生成的补丁，请在 Windows 上运行 testdata/gen.cmd 生成 - %v", err)
+				}
+				got, err := applyBytes(old, diff)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("输出与 hpatchz 的结果不一致 (%d / %d 字节)", len(got), len(want))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package hpatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 覆盖区: 新数据 [newPos, newPos+length) 由旧数据 [oldPos, oldPos+length) 加上 RLE 差值得到
+type cover struct {
+	oldPos, newPos, length uint64
+}
+
+// 覆盖区依次存放: 旧位置增量 (1 位符号标记)、新位置增量、长度
+type coverReader struct {
+	r          io.ByteReader
+	lastOldEnd uint64
+	lastNewEnd uint64
+}
+
+func (c *coverReader) next() (cover, error) {
+	inc, sign, err := readUint(c.r, 1)
+	if err != nil {
+		return cover{}, err
+	}
+	var cv cover
+	if sign == 0 {
+		cv.oldPos = c.lastOldEnd + inc
+	} else {
+		if inc > c.lastOldEnd {
+			return cover{}, errCover
+		}
+		cv.oldPos = c.lastOldEnd - inc
+	}
+	if inc, err = readSize(c.r); err != nil {
+		return cover{}, err
+	}
+	cv.newPos = c.lastNewEnd + inc
+	if cv.length, err = readSize(c.r); err != nil {
+		return cover{}, err
+	}
+	c.lastOldEnd = cv.oldPos + cv.length
+	c.lastNewEnd = cv.newPos + cv.length
+	return cv, nil
+}
+
+var errCover = errors.New("补丁的覆盖区数据错误")
+
+type patcher struct {
+	old     io.ReaderAt
+	oldSize uint64
+	newSize uint64
+	w       *bufio.Writer
+	written uint64
+	buf     [1 << 16]byte
+}
+
+// 从新增数据流复制 n 字节
+func (p *patcher) copyNew(r io.Reader, n uint64) error {
+	if n > p.newSize-p.written {
+		return errCover
+	}
+	if _, err := io.CopyN(p.w, r, int64(n)); err != nil {
+		return fmt.Errorf("补丁的新增数据不完整 - %v", unexpected(err))
+	}
+	p.written += n
+	return nil
+}
+
+// 先补上覆盖区之前的新增数据，再写出覆盖区
+func (p *patcher) writeCover(cv cover, newData io.Reader, rle *rleDecoder) error {
+	if cv.newPos < p.written || cv.newPos > p.newSize || cv.length > p.newSize-cv.newPos ||
+		cv.oldPos > p.oldSize || cv.length > p.oldSize-cv.oldPos {
+		return errCover
+	}
+	if err := p.copyNew(newData, cv.newPos-p.written); err != nil {
+		return err
+	}
+	for off, left := cv.oldPos, cv.length; left > 0; {
+		chunk := p.buf[:min(left, uint64(len(p.buf)))]
+		if _, err := p.old.ReadAt(chunk, int64(off)); err != nil {
+			return fmt.Errorf("读取旧文件失败 - %v", err)
+		}
+		if err := rle.add(chunk); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(chunk); err != nil {
+			return err
+		}
+		n := uint64(len(chunk))
+		off, left, p.written = off+n, left-n, p.written+n
+	}
+	return nil
+}
+
+// HDIFF13: 四段数据分别存放（可分别压缩），同时顺序读取
+func (p *patcher) applyHDIFF13(h *Header, diff io.ReaderAt) error {
+	var readers [4]*bufio.Reader
+	for i, s := range []section{h.covers, h.rleCtrl, h.rleCode, h.newData} {
+		r, closeFn, err := h.open(diff, s)
+		if err != nil {
+			return err
+		}
+		defer closeFn()
+		readers[i] = r
+	}
+	covers := &coverReader{r: readers[0]}
+	rle := &rleDecoder{ctrl: readers[1], code: readers[2]}
+	newData := readers[3]
+	for i := uint64(0); i < h.coverCount; i++ {
+		cv, err := covers.next()
+		if err != nil {
+			return errCover
+		}
+		if err := p.writeCover(cv, newData, rle); err != nil {
+			return err
+		}
+	}
+	return p.copyNew(newData, p.newSize-p.written)
+}
+
+// HDIFFSF20: 单个数据流，按步存放覆盖区和 RLE 缓冲，覆盖区之间的新增数据紧随其后
+func (p *patcher) applySingle(h *Header, diff io.ReaderAt) error {
+	r, closeFn, err := h.open(diff, h.data)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	covers := &coverReader{}
+	left := h.coverCount
+	for left > 0 {
+		var coversSize, rleSize uint64
+		if coversSize, err = readSize(r); err == nil {
+			rleSize, err = readSize(r)
+		}
+		// stepMemSize 已在读取文件头时限制
+		if err != nil || coversSize > h.stepMemSize || rleSize > h.stepMemSize-coversSize {
+			return fmt.Errorf("%w: 分步数据错误", ErrCorrupt)
+		}
+		step := make([]byte, coversSize+rleSize)
+		if _, err := io.ReadFull(r, step); err != nil {
+			return fmt.Errorf("补丁的分步数据不完整 - %v", unexpected(err))
+		}
+		coverBuf := bytes.NewReader(step[:coversSize])
+		rleBuf := bytes.NewReader(step[coversSize:])
+		ctrlSize, err := readSize(rleBuf)
+		if err != nil || ctrlSize > uint64(rleBuf.Len()) {
+			return errRLE
+		}
+		ctrlStart := int(rleSize) - rleBuf.Len()
+		rle := &rleDecoder{
+			ctrl: bytes.NewReader(step[coversSize:][ctrlStart : ctrlStart+int(ctrlSize)]),
+			code: bytes.NewReader(step[coversSize:][ctrlStart+int(ctrlSize):]),
+		}
+		covers.r = coverBuf
+		for left > 0 && coverBuf.Len() > 0 {
+			cv, err := covers.next()
+			if err != nil {
+				return errCover
+			}
+			if err := p.writeCover(cv, r, rle); err != nil {
+				return err
+			}
+			left--
+		}
+	}
+	return p.copyNew(r, p.newSize-p.written)
+}
@@ -0,0 +1,77 @@
+package hpatch
+
+import (
+	"errors"
+	"io"
+)
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// RLE 控制字节的类型，占高 2 位
+const (
+	rleZero  = 0 // 连续的 0
+	rle255   = 1 // 连续的 255
+	rleValue = 2 // 连续的同一个字节，值在数据区
+	rleRaw   = 3 // 未压缩的字节，在数据区
+)
+
+// 覆盖区的差值，按 RLE 解码后逐字节加到旧数据上
+// 一段 RLE 可以跨越多个覆盖区，所以要保留未用完的长度
+type rleDecoder struct {
+	ctrl     io.ByteReader
+	code     byteReader
+	setLen   uint64
+	setValue byte
+	copyLen  uint64
+	scratch  [4096]byte
+}
+
+var errRLE = errors.New("补丁的 RLE 数据不完整")
+
+func (d *rleDecoder) add(buf []byte) error {
+	for len(buf) > 0 {
+		switch {
+		case d.setLen > 0:
+			n := min(uint64(len(buf)), d.setLen)
+			if v := d.setValue; v != 0 {
+				for i := range buf[:n] {
+					buf[i] += v
+				}
+			}
+			buf, d.setLen = buf[n:], d.setLen-n
+		case d.copyLen > 0:
+			n := min(uint64(len(buf)), d.copyLen, uint64(len(d.scratch)))
+			if _, err := io.ReadFull(d.code, d.scratch[:n]); err != nil {
+				return errRLE
+			}
+			for i, v := range d.scratch[:n] {
+				buf[i] += v
+			}
+			buf, d.copyLen = buf[n:], d.copyLen-n
+		default:
+			v, typ, err := readUint(d.ctrl, 2)
+			if err != nil {
+				return errRLE
+			}
+			length := v + 1
+			switch typ {
+			case rleZero:
+				d.setLen, d.setValue = length, 0
+			case rle255:
+				d.setLen, d.setValue = length, 255
+			case rleValue:
+				b, err := d.code.ReadByte()
+				if err != nil {
+					return errRLE
+				}
+				d.setLen, d.setValue = length, b
+			case rleRaw:
+				d.copyLen = length
+			}
+		}
+	}
+	return nil
+}
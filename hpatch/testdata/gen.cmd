@@ -0,0 +1,19 @@
+@echo off
+rem 用 hdiffz 重新生成 hpatch 包的测试样例，需要 PATH 中有 hdiffz.exe 和 hpatchz.exe
+rem 输出 sample.<压缩>.<格式>.hdiff，并用 hpatchz 确认结果与 sample.new 一致
+setlocal
+cd /d "%~dp0"
+for %%c in (raw zstd zlib lzma) do (
+    call :gen %%c hdiff13 ""
+    call :gen %%c sf20 "-SD"
+)
+del /q out.tmp 2>nul
+exit /b 0
+
+:gen
+set "comp="
+if not "%1"=="raw" set "comp=-c-%1"
+hdiffz -f %~3 %comp% sample.old sample.new sample.%1.%2.hdiff || exit /b 1
+hpatchz -f sample.old sample.%1.%2.hdiff out.tmp || exit /b 1
+fc /b out.tmp sample.new >nul || (echo mismatch: sample.%1.%2.hdiff & exit /b 1)
+exit /b 0
@@ -0,0 +1,43 @@
+package hpatch
+
+import (
+	"errors"
+	"io"
+)
+
+var errOverflow = errors.New("整数编码溢出")
+
+// HDiffPatch 的变长整数: 首字节高 tagBits 位为标记，随后一位表示是否还有后续字节，
+// 其余位和后续字节的低 7 位按大端顺序拼出数值
+func readUint(r io.ByteReader, tagBits uint) (uint64, byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, unexpected(err)
+	}
+	tag := byte(uint(b) >> (8 - tagBits))
+	more := byte(1) << (7 - tagBits)
+	v := uint64(b & (more - 1))
+	for b&more != 0 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, 0, unexpected(err)
+		}
+		if v>>57 != 0 {
+			return 0, 0, errOverflow
+		}
+		v = v<<7 | uint64(b&0x7f)
+		more = 0x80
+	}
+	return v, tag, nil
+}
+
+func readSize(r io.ByteReader) (uint64, error) {
+	v, _, err := readUint(r, 0)
+	return v, err
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+// 从 hdiffz/hpatchz 命令行解析出的界面设置
+type importedCommand struct {
+	Tool        string // hdiffz / hpatchz，命令中没有程序名时为空
+	Apply       bool   // --patch 或 hpatchz
+	Test        bool   // -t
+	Paths       []string
+	Format      int
+	MatchMode   int
+	Compress    bool
+	Overwrite   bool
+	SkipVerify  bool
+	Unsupported []string // 界面无法表示、导入时忽略的参数
+	Extra       []string // 界面没有对应控件的选项，放入额外参数
+}
+
+// 按 Windows (CommandLineToArgvW) 规则拆分命令行:
+// 空白分隔参数，引号内的空白保留，"" 在引号内表示一个引号；
+// 紧跟引号的 2n 个反斜杠变成 n 个，2n+1 个时再加一个字面引号，其余反斜杠原样保留
+func splitCommandLine(s string) []string {
+	// 批处理 (^) 和 PowerShell (`) 的续行
+	s = strings.NewReplacer("^\r\n", " ", "^\n", " ", "`\r\n", " ", "`\n", " ").Replace(s)
+	var args []string
+	var cur strings.Builder
+	inArg, inQuote := false, false
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\':
+			n := 0
+			for i < len(s) && s[i] == '\\' {
+				n++
+				i++
+			}
+			if i < len(s) && s[i] == '"' {
+				cur.WriteString(strings.Repeat(`\`, n/2))
+				if n%2 == 1 {
+					cur.WriteByte('"')
+					i++
+				}
+			} else {
+				cur.WriteString(strings.Repeat(`\`, n))
+			}
+			inArg = true
+		case c == '"':
+			if inQuote && i+1 < len(s) && s[i+1] == '"' {
+				cur.WriteByte('"')
+				i += 2
+			} else {
+				inQuote = !inQuote
+				i++
+			}
+			inArg = true
+		case !inQuote && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+			i++
+		default:
+			cur.WriteByte(c)
+			inArg = true
+			i++
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args
+}
+
+// 解析 hdiffz / hpatchz 命令行，程序名可以省略
+func parseCommandLine(line string) (*importedCommand, error) {
+	args := splitCommandLine(line)
+	if len(args) == 0 {
+		return nil, errors.New("命令行为空")
+	}
+	c := &importedCommand{}
+	tool := strings.TrimSuffix(strings.ToLower(filepath.Base(args[0])), ".exe")
+	if tool == "hdiffz" || tool == "hpatchz" {
+		c.Tool = tool
+		c.Apply = tool == "hpatchz"
+		args = args[1:]
+	}
+	for _, a := range args {
+		if a == "--patch" {
+			c.Apply = true
+		}
+	}
+	ignore := func(a, why string) {
+		c.Unsupported = append(c.Unsupported, fmt.Sprintf("%s (%s)", a, why))
+	}
+
+	compress := ""
+	for _, a := range args {
+		if len(a) < 2 || a[0] != '-' {
+			c.Paths = append(c.Paths, a)
+			continue
+		}
+		opt := optionOf(a)
+		switch {
+		case a == "--patch":
+		case a == "-f":
+			c.Overwrite = true
+		case a == "-d" && !c.Apply:
+			c.SkipVerify = true
+		case a == "-t" && !c.Apply:
+			c.Test = true
+		case (opt == "-m" || opt == "-s") && !c.Apply:
+			c.MatchMode = 0
+			if opt == "-s" {
+				c.MatchMode = 1
+			}
+			if a != opt {
+				ignore(a, "界面不支持设置匹配参数，使用默认值")
+			}
+		case (opt == "-SD" || opt == "-BSD" || opt == "-VCD") && !c.Apply:
+			c.Format = formatIndex(opt)
+			if a != opt {
+				ignore(a, "界面不支持设置格式参数，使用默认值")
+			}
+		case compressorOf(a) != "" && !c.Apply:
+			compress = a
+		default:
+			c.Extra = append(c.Extra, a)
+		}
+	}
+
+	if !c.Apply {
+		f := patchFormatAt(c.Format)
+		switch {
+		case compress == "":
+		case compress == f.Compress || compress == f.Forced:
+			c.Compress = true
+		case f.Compress != "":
+			c.Compress = true
+			ignore(compress, "界面只支持 "+f.Compress+"，已改用该压缩参数")
+		default:
+			ignore(compress, f.Name+" 格式不支持该压缩参数")
+		}
+	}
+	switch {
+	case len(c.Paths) > 3:
+		for _, p := range c.Paths[3:] {
+			ignore(p, "多余的路径")
+		}
+		c.Paths = c.Paths[:3]
+	case len(c.Paths) < 3:
+		ignore(fmt.Sprintf("%d 个路径", len(c.Paths)), "需要 3 个路径，缺少的路径请手动填写")
+	}
+	return c, nil
+}
+
+func (c *importedCommand) path(i int) string {
+	if i < len(c.Paths) {
+		return c.Paths[i]
+	}
+	return ""
+}
+
+// 弹出对话框粘贴命令行，解析后填入对应的页面
+func (mw *AppMainWindow) importCommand(tab int) {
+	var dlg *walk.Dialog
+	var edit *walk.TextEdit
+	var okBtn, cancelBtn *walk.PushButton
+	text := ""
+	_, err := Dialog{
+		AssignTo:      &dlg,
+		Title:         "导入命令行",
+		DefaultButton: &okBtn,
+		CancelButton:  &cancelBtn,
+		MinSize:       Size{Width: 600, Height: 220},
+		Layout:        VBox{},
+		Children: []Widget{
+			Label{Text: "粘贴 hdiffz 或 hpatchz 命令行 (可以包含程序路径，支持 ^ / ` 续行):"},
+			TextEdit{AssignTo: &edit, VScroll: true},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						AssignTo: &okBtn,
+						Text:     "导入",
+						OnClicked: func() {
+							text = edit.Text()
+							dlg.Accept()
+						},
+					},
+					PushButton{
+						AssignTo:  &cancelBtn,
+						Text:      "取消",
+						OnClicked: func() { dlg.Cancel() },
+					},
+				},
+			},
+		},
+	}.Run(mw.MainWindow)
+	if err != nil {
+		mw.tabLog(tab).Errorf("打开导入对话框失败 - %v", err)
+		return
+	}
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	c, err := parseCommandLine(text)
+	if err != nil {
+		mw.tabLog(tab).Errorf("导入命令行失败 - %v", err)
+		return
+	}
+	mw.applyImported(c)
+}
+
+func (mw *AppMainWindow) applyImported(c *importedCommand) {
+	var log *LogSink
+	extra := make([]string, len(c.Extra))
+	for i, e := range c.Extra {
+		extra[i] = syscall.EscapeArg(e)
+	}
+	if c.Apply {
+		t := mw.ApplyTab
+		log = t.Log
+		t.ExtraArgsEdit.SetText(strings.Join(extra, " "))
+		t.BackendCombo.SetCurrentIndex(backendTool)
+		t.InPlaceCheck.SetChecked(false)
+		t.OverwriteCheck.SetChecked(c.Overwrite)
+		t.OldPathEdit.SetText(c.path(0))
+		t.PatchPathEdit.SetText(c.path(1))
+		t.OutPutEdit.SetText(c.path(2))
+		mw.TabWidget.SetCurrentIndex(tabApply)
+	} else {
+		t := mw.PatchTab
+		log = t.Log
+		// 先设置格式，再设置压缩，切换格式会重置压缩选项
+		t.BackendCombo.SetCurrentIndex(backendTool)
+		t.FormatCombo.SetCurrentIndex(c.Format)
+		mw.updateDiffBackend()
+		if t.MatchModeCombo.Enabled() {
+			t.MatchModeCombo.SetCurrentIndex(c.MatchMode)
+		} else if c.MatchMode != 0 {
+			log.Warn("当前 hdiffz 不支持流式匹配 (-s)，已使用内存匹配")
+		}
+		if t.CompressCheck.Enabled() {
+			t.CompressCheck.SetChecked(c.Compress)
+		}
+		t.OverwriteCheck.SetChecked(c.Overwrite)
+		t.SkipVerifyCheck.SetChecked(c.SkipVerify)
+		t.ExtraArgsEdit.SetText(strings.Join(extra, " "))
+		// 补丁路径最后设置，避免被自动命名覆盖
+		t.OldPathEdit.SetText(c.path(0))
+		t.NewPathEdit.SetText(c.path(1))
+		t.OutPutEdit.SetText(c.path(2))
+		mw.TabWidget.SetCurrentIndex(tabPatch)
+	}
+	kind := "生成补丁"
+	if c.Apply {
+		kind = "应用补丁"
+	}
+	log.Infof("已导入%s命令", kind)
+	if c.Test {
+		log.Info("命令为 -t 验证补丁，可点击\"验证\"按钮执行")
+	}
+	if len(extra) > 0 {
+		log.Info("界面中没有对应选项，已放入额外参数: " + strings.Join(extra, " "))
+	}
+	for _, u := range c.Unsupported {
+		log.Warn("已忽略: " + u)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"空", "  \t ", nil},
+		{"空白分隔", "hdiffz -f  old.bin\tnew.bin", []string{"hdiffz", "-f", "old.bin", "new.bin"}},
+		{"带空格的路径", `hdiffz "C:\Program Files\app\old.exe" "D:\my dir\new.exe"`,
+			[]string{"hdiffz", `C:\Program Files\app\old.exe`, `D:\my dir\new.exe`}},
+		{"引号在参数中间", `C:\a" b"\c.bin`, []string{`C:\a b\c.bin`}},
+		{"空参数", `a "" b`, []string{"a", "", "b"}},
+		{"引号内的两个引号", `"a""b" c`, []string{`a"b`, "c"}},
+		{"不在反斜杠后的反斜杠原样保留", `C:\dir\sub\file.bin \\server\share`, []string{`C:\dir\sub\file.bin`, `\\server\share`}},
+		{"结尾反斜杠加转义", `"C:\dir\\" next`, []string{`C:\dir\`, "next"}},
+		{"结尾单个反斜杠转义了引号", `"C:\dir\" next`, []string{`C:\dir" next`}},
+		{"2n 个反斜杠加引号", `a\\\\"b c"`, []string{`a\\b c`}},
+		{"2n+1 个反斜杠加引号", `a\\\"b`, []string{`a\"b`}},
+		{"单个反斜杠加引号", `\"quoted\"`, []string{`"quoted"`}},
+		{"批处理续行", "hdiffz -f ^\r\n  old.bin ^\n new.bin out.hdiff", []string{"hdiffz", "-f", "old.bin", "new.bin", "out.hdiff"}},
+		{"PowerShell 续行", "hdiffz -s `\r\n old.bin `\n new.bin", []string{"hdiffz", "-s", "old.bin", "new.bin"}},
+		{"不在行尾的 ^ 和 `", "a^b c`d", []string{"a^b", "c`d"}},
+		{"换行分隔", "a\r\nb\nc", []string{"a", "b", "c"}},
+		{"未闭合的引号", `"C:\my dir\old.bin`, []string{`C:\my dir\old.bin`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitCommandLine(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitCommandLine(%q)\ngot  %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		want        importedCommand
+		unsupported []string // Unsupported 中应包含的参数
+	}{
+		{
+			name: "生成补丁",
+			in:   `"C:\Tools\hdiffz.exe" -f -d -c-zstd-21-24 "C:\my app\v1" "C:\my app\v2" "D:\out\\"`,
+			want: importedCommand{Tool: "hdiffz", Paths: []string{`C:\my app\v1`, `C:\my app\v2`, `D:\out\`},
+				Compress: true, Overwrite: true, SkipVerify: true},
+		},
+		{
+			name:        "省略程序名",
+			in:          "-s-64 -SD old.bin new.bin out.sdiff",
+			want:        importedCommand{Paths: []string{"old.bin", "new.bin", "out.sdiff"}, Format: formatIndex("-SD"), MatchMode: 1},
+			unsupported: []string{"-s-64"},
+		},
+		{
+			name: "BSD 使用固定压缩",
+			in:   "hdiffz -BSD -c-bzip2-9 a b c",
+			want: importedCommand{Tool: "hdiffz", Paths: []string{"a", "b", "c"}, Format: formatIndex("-BSD"), Compress: true},
+		},
+		{
+			name:        "界面只支持默认压缩参数",
+			in:          "hdiffz -c-lzma2-9 a b c",
+			want:        importedCommand{Tool: "hdiffz", Paths: []string{"a", "b", "c"}, Compress: true},
+			unsupported: []string{"-c-lzma2-9"},
+		},
+		{
+			name: "验证补丁",
+			in:   "hdiffz -t old new patch",
+			want: importedCommand{Tool: "hdiffz", Test: true, Paths: []string{"old", "new", "patch"}},
+		},
+		{
+			name: "hpatchz",
+			in:   `hpatchz.EXE -f -s-4m old.bin "my patch.hdiff" new.bin`,
+			want: importedCommand{Tool: "hpatchz", Apply: true, Overwrite: true,
+				Paths: []string{"old.bin", "my patch.hdiff", "new.bin"}, Extra: []string{"-s-4m"}},
+		},
+		{
+			name: "hdiffz --patch",
+			in:   "hdiffz --patch -p-4 old patch new",
+			want: importedCommand{Tool: "hdiffz", Apply: true, Paths: []string{"old", "patch", "new"}, Extra: []string{"-p-4"}},
+		},
+		{
+			name: "没有对应控件的选项",
+			in:   "hdiffz -p-8 -cache a b c",
+			want: importedCommand{Tool: "hdiffz", Paths: []string{"a", "b", "c"}, Extra: []string{"-p-8", "-cache"}},
+		},
+		{
+			name:        "路径太多",
+			in:          "hdiffz a b c d",
+			want:        importedCommand{Tool: "hdiffz", Paths: []string{"a", "b", "c"}},
+			unsupported: []string{"d"},
+		},
+		{
+			name: "名为 - 的路径",
+			in:   "hdiffz - b c",
+			want: importedCommand{Tool: "hdiffz", Paths: []string{"-", "b", "c"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCommandLine(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, u := range tt.unsupported {
+				found := false
+				for _, got := range c.Unsupported {
+					found = found || strings.HasPrefix(got, u+" ")
+				}
+				if !found {
+					t.Errorf("Unsupported %q 中没有 %s", c.Unsupported, u)
+				}
+			}
+			if len(tt.unsupported) == 0 && len(c.Unsupported) > 0 {
+				t.Errorf("Unsupported = %q", c.Unsupported)
+			}
+			c.Unsupported = nil
+			if !reflect.DeepEqual(*c, tt.want) {
+				t.Fatalf("got  %+v\nwant %+v", *c, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommandLineMissingPaths(t *testing.T) {
+	c, err := parseCommandLine("hdiffz -f old.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.path(0) != "old.bin" || c.path(1) != "" || c.path(2) != "" || len(c.Unsupported) != 1 {
+		t.Fatalf("got %+v", c)
+	}
+	if _, err := parseCommandLine(" ^\r\n "); err == nil {
+		t.Fatal("空命令行应返回错误")
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lxn/walk"
+)
+
+// 事务目录中最多保留的更新记录数量（包含备份文件，占用空间较大）
+const maxTransactions = 10
+
+const (
+	txApplying   = "applying"
+	txCommitted  = "committed"
+	txRolledBack = "rolledback"
+)
+
+// 事务 ID 即事务目录名: 补零的 UTC 时间戳，按名称排序就是按时间排序，不受时区和夏令时影响
+const txIDLayout = "20060102-150405.000000000"
+
+const (
+	opAdd    = "add"
+	opModify = "modify"
+	opRemove = "remove"
+)
+
+// 一项改动，Path 为相对目标的路径，目标本身是文件时为 "."
+type txEntry struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Dir    bool   `json:"dir,omitempty"`
+}
+
+// 就地更新的事务日志，改动前写入，回滚时按相反顺序撤销
+type txJournal struct {
+	ID      string     `json:"id"`
+	Time    time.Time  `json:"time"`
+	Target  string     `json:"target"`
+	Patch   string     `json:"patch"`
+	State   string     `json:"state"`
+	Before  PathDigest `json:"before"`
+	After   PathDigest `json:"after"`
+	Entries []txEntry  `json:"entries"`
+
+	dir string
+}
+
+func transactionsDir() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "transactions")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func (tx *txJournal) backupPath(e txEntry) string {
+	return filepath.Join(tx.dir, "backup", filepath.FromSlash(e.Path))
+}
+
+func (tx *txJournal) targetPath(e txEntry) string {
+	return filepath.Join(tx.Target, filepath.FromSlash(e.Path))
+}
+
+func (tx *txJournal) save() error {
+	data, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(tx.dir, "journal.json"), data, 0644)
+}
+
+func loadJournal(dir string) (*txJournal, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		return nil, err
+	}
+	tx := &txJournal{dir: dir}
+	if err := json.Unmarshal(data, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// 最近一次就地更新；已经回滚时不再往前找，更早的备份与目标当前的状态对不上
+func lastJournal() (*txJournal, error) {
+	root, err := transactionsDir()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if !dirs[i].IsDir() {
+			continue
+		}
+		tx, err := loadJournal(filepath.Join(root, dirs[i].Name()))
+		if err != nil {
+			return nil, fmt.Errorf("读取事务日志 %s 失败 - %v", dirs[i].Name(), err)
+		}
+		if tx.State != txApplying && tx.State != txCommitted {
+			return nil, os.ErrNotExist
+		}
+		return tx, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func rotateTransactions(root string, keep int) {
+	dirs, err := os.ReadDir(root)
+	if err != nil || len(dirs) <= keep {
+		return
+	}
+	for _, d := range dirs[:len(dirs)-keep] {
+		_ = os.RemoveAll(filepath.Join(root, d.Name()))
+	}
+}
+
+// 比较目标和新数据，列出需要的改动，顺序即执行顺序:
+// 删除文件 -> 删除文件夹(由深到浅) -> 新建文件夹(由浅到深) -> 新增/修改文件
+func diffTrees(target, staged string) ([]txEntry, error) {
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	stagedInfo, err := os.Stat(staged)
+	if err != nil {
+		return nil, err
+	}
+	if !targetInfo.IsDir() && !stagedInfo.IsDir() {
+		same, err := sameFile(target, staged)
+		if err != nil || same {
+			return nil, err
+		}
+		return []txEntry{{Path: ".", Action: opModify}}, nil
+	}
+	if targetInfo.IsDir() != stagedInfo.IsDir() {
+		return nil, fmt.Errorf("补丁结果与目标类型不同（文件/文件夹），无法就地更新")
+	}
+
+	oldTree, err := listTree(target)
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := listTree(staged)
+	if err != nil {
+		return nil, err
+	}
+	var removeFiles, removeDirs, addDirs, writeFiles []txEntry
+	for rel, isDir := range oldTree {
+		newIsDir, ok := newTree[rel]
+		if ok && newIsDir == isDir {
+			continue
+		}
+		if isDir {
+			removeDirs = append(removeDirs, txEntry{Path: rel, Action: opRemove, Dir: true})
+		} else {
+			removeFiles = append(removeFiles, txEntry{Path: rel, Action: opRemove})
+		}
+	}
+	for rel, isDir := range newTree {
+		oldIsDir, ok := oldTree[rel]
+		switch {
+		case ok && oldIsDir == isDir && isDir:
+			continue
+		case ok && oldIsDir == isDir:
+			same, err := sameFile(filepath.Join(target, filepath.FromSlash(rel)), filepath.Join(staged, filepath.FromSlash(rel)))
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				writeFiles = append(writeFiles, txEntry{Path: rel, Action: opModify})
+			}
+		case isDir:
+			addDirs = append(addDirs, txEntry{Path: rel, Action: opAdd, Dir: true})
+		default:
+			writeFiles = append(writeFiles, txEntry{Path: rel, Action: opAdd})
+		}
+	}
+	byPath := func(list []txEntry, deepFirst bool) {
+		sort.Slice(list, func(i, k int) bool {
+			if deepFirst {
+				return list[i].Path > list[k].Path
+			}
+			return list[i].Path < list[k].Path
+		})
+	}
+	byPath(removeFiles, false)
+	byPath(removeDirs, true)
+	byPath(addDirs, false)
+	byPath(writeFiles, false)
+
+	entries := append(removeFiles, removeDirs...)
+	entries = append(entries, addDirs...)
+	return append(entries, writeFiles...), nil
+}
+
+// 相对路径(使用 /) -> 是否为文件夹
+func listTree(root string) (map[string]bool, error) {
+	tree := map[string]bool{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = d.IsDir()
+		return nil
+	})
+	return tree, err
+}
+
+func sameFile(a, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if ai.Size() != bi.Size() {
+		return false, nil
+	}
+	ha, err := sha256File(a)
+	if err != nil {
+		return false, err
+	}
+	hb, err := sha256File(b)
+	if err != nil {
+		return false, err
+	}
+	return ha == hb, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// 备份将被修改或删除的文件
+func (tx *txJournal) backup() error {
+	for _, e := range tx.Entries {
+		if e.Dir || e.Action == opAdd {
+			continue
+		}
+		if err := copyFile(tx.targetPath(e), tx.backupPath(e)); err != nil {
+			return fmt.Errorf("备份 %s 失败 - %v", e.Path, err)
+		}
+	}
+	return nil
+}
+
+// 按顺序执行改动，新文件从暂存目录直接 rename 到目标位置
+func (tx *txJournal) apply(staged string) error {
+	for _, e := range tx.Entries {
+		dst := tx.targetPath(e)
+		var err error
+		switch {
+		case e.Action == opRemove:
+			err = os.Remove(dst)
+		case e.Dir:
+			err = os.MkdirAll(dst, 0755)
+		default:
+			err = os.Rename(filepath.Join(staged, filepath.FromSlash(e.Path)), dst)
+		}
+		if err != nil {
+			return fmt.Errorf("更新 %s 失败 - %v", e.Path, err)
+		}
+	}
+	return nil
+}
+
+// 按相反顺序撤销改动，尽量继续处理剩余项并返回第一个错误
+func (tx *txJournal) rollback() error {
+	var firstErr error
+	for i := len(tx.Entries) - 1; i >= 0; i-- {
+		e := tx.Entries[i]
+		dst := tx.targetPath(e)
+		var err error
+		switch {
+		case e.Action == opAdd:
+			err = os.Remove(dst)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		case e.Dir:
+			err = os.MkdirAll(dst, 0755)
+		default:
+			err = copyFile(tx.backupPath(e), dst)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("恢复 %s 失败 - %v", e.Path, err)
+		}
+	}
+	return firstErr
+}
+
+// 就地更新: 先把补丁应用到暂存目录并校验，再备份将改动的文件、写入事务日志，
+// 最后替换目标；替换或最终校验失败时根据事务日志恢复
+func (mw *AppMainWindow) applyInPlace() {
+	targetPath, patches, err := mw.applyInputs()
+	if err != nil {
+		mw.ApplyTab.Log.Error(err.Error())
+		return
+	}
+
+	mw.runApplyJob("就地更新", func(j *job) error {
+		if err := j.preflightApply(targetPath, patches, targetPath, true); err != nil {
+			return err
+		}
+		stagedPath := tempSibling(targetPath, j.ID)
+		workDir := stagedPath + ".steps"
+		defer os.RemoveAll(stagedPath)
+		defer os.RemoveAll(workDir)
+		if _, err := j.applyChain(targetPath, patches, stagedPath, workDir, ""); err != nil {
+			return err
+		}
+		after, err := digestPath(stagedPath)
+		if err != nil {
+			return err
+		}
+		before, err := digestPath(targetPath)
+		if err != nil {
+			return err
+		}
+
+		entries, err := diffTrees(targetPath, stagedPath)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			j.Log.Info("目标已是最新，无需更新")
+			return nil
+		}
+		j.Log.Infof("需要改动 %d 项", len(entries))
+
+		root, err := transactionsDir()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		id := now.UTC().Format(txIDLayout)
+		tx := &txJournal{
+			ID:      id,
+			Time:    now,
+			Target:  targetPath,
+			Patch:   joinPatchList(patches),
+			State:   txApplying,
+			Before:  before,
+			After:   after,
+			Entries: entries,
+			dir:     filepath.Join(root, id),
+		}
+		if err := os.MkdirAll(tx.dir, 0755); err != nil {
+			return err
+		}
+		if err := tx.backup(); err != nil {
+			os.RemoveAll(tx.dir)
+			return err
+		}
+		if err := tx.save(); err != nil {
+			os.RemoveAll(tx.dir)
+			return fmt.Errorf("写入事务日志失败 - %v", err)
+		}
+		j.Log.Info("已备份到: " + tx.dir)
+
+		err = tx.apply(stagedPath)
+		if err == nil {
+			var got PathDigest
+			if got, err = digestPath(targetPath); err == nil && got.SHA256 != after.SHA256 {
+				err = fmt.Errorf("更新后的目标与补丁结果不一致")
+			}
+		}
+		if err != nil {
+			j.Log.Errorf("%v，正在回滚...", err)
+			if rerr := tx.rollback(); rerr != nil {
+				return fmt.Errorf("回滚失败 - %v，备份保留在 %s", rerr, tx.dir)
+			}
+			tx.State = txRolledBack
+			_ = tx.save()
+			return fmt.Errorf("已回滚到更新前的状态 - %v", err)
+		}
+		tx.State = txCommitted
+		if err := tx.save(); err != nil {
+			j.Log.Warnf("更新事务日志失败 - %v", err)
+		}
+		rotateTransactions(root, maxTransactions)
+		j.Log.Info("已就地更新: " + targetPath)
+		return nil
+	})
+}
+
+// 根据最近一次就地更新的事务日志恢复目标
+func (mw *AppMainWindow) rollbackLastApply() {
+	tx, err := lastJournal()
+	if errors.Is(err, os.ErrNotExist) {
+		mw.ApplyTab.Log.Error("没有可回滚的就地更新记录")
+		return
+	}
+	if err != nil {
+		mw.ApplyTab.Log.Error(err.Error())
+		return
+	}
+	msg := fmt.Sprintf("将 %s 回滚到 %s 更新前的状态 (%d 项改动)，是否继续？",
+		tx.Target, tx.Time.Format("2006-01-02 15:04:05"), len(tx.Entries))
+	if walk.MsgBox(mw, "回滚上次更新", msg, walk.MsgBoxYesNo|walk.MsgBoxIconQuestion) != walk.DlgCmdYes {
+		return
+	}
+
+	mw.runJob(tabApply, "回滚", func(j *job) error {
+		j.Log.Infof("事务 %s: %s <- %s", tx.ID, tx.Target, tx.Patch)
+		if tx.State == txCommitted {
+			got, err := digestPath(tx.Target)
+			if err != nil {
+				return err
+			}
+			if got.SHA256 != tx.After.SHA256 {
+				j.Log.Warn("目标在更新后又被修改过，回滚会覆盖这些修改")
+			}
+		}
+		if err := tx.rollback(); err != nil {
+			return err
+		}
+		got, err := digestPath(tx.Target)
+		if err != nil {
+			return err
+		}
+		if got.SHA256 != tx.Before.SHA256 {
+			j.Log.Warn("回滚后的目标与更新前的记录不一致")
+		}
+		tx.State = txRolledBack
+		return tx.save()
+	})
+}
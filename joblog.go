@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 日志目录中最多保留的任务日志数量
+const maxLogFiles = 100
+
+var jobSeq atomic.Uint32
+
+// 一次后台任务（可能包含多次 hdiffz 调用），拥有自己的日志 sink
+type job struct {
+	ID  string
+	Tab int
+	Log *LogSink
+	// 应用加密补丁时使用的口令/私钥
+	Decrypt *cryptOptions
+	// 应用补丁的后端，见 backendTool / backendGo
+	Backend int
+	// 应用补丁时附加给 hdiffz --patch 的额外参数
+	ExtraArgs []string
+
+	fileMu sync.Mutex
+	file   *os.File
+}
+
+func newJob(tab int) *job {
+	id := fmt.Sprintf("%s-%03d", time.Now().Format("20060102-150405"), jobSeq.Add(1)%1000)
+	return &job{
+		ID:  id,
+		Tab: tab,
+		Log: newLogSink(id),
+	}
+}
+
+// 程序数据目录 (%APPDATA%\hdiffz-gui)
+func appDataDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "hdiffz-gui")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func logDir() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "logs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// 创建任务日志文件 <日志目录>\<任务ID>.log，并清理超出数量的旧日志
+func (j *job) openLog() error {
+	dir, err := logDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, j.ID+".log"))
+	if err != nil {
+		return err
+	}
+	j.file = f
+	j.Log.Subscribe(j.writeLog)
+	rotateLogs(dir, maxLogFiles)
+	return nil
+}
+
+func (j *job) closeLog() {
+	j.fileMu.Lock()
+	defer j.fileMu.Unlock()
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+}
+
+// 追加到任务日志文件
+func (j *job) writeLog(e LogEntry) {
+	j.fileMu.Lock()
+	defer j.fileMu.Unlock()
+	if j.file != nil {
+		fmt.Fprintf(j.file, "%s\r\n", e)
+	}
+}
+
+// 按 Windows 命令行规则拼接参数，与 exec.Command 实际传给进程的一致
+func commandLine(tool string, args []string) string {
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, syscall.EscapeArg(tool))
+	for _, arg := range args {
+		parts = append(parts, syscall.EscapeArg(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// 只保留最新的 keep 个日志文件，任务 ID 以时间开头，按文件名排序即按时间排序
+func rotateLogs(dir string, keep int) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil || len(files) <= keep {
+		return
+	}
+	sort.Strings(files)
+	for _, f := range files[:len(files)-keep] {
+		_ = os.Remove(f)
+	}
+}
+
+// 返回与本程序同目录的工具路径
+func findTool(name string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("无法获取程序路径 - %v", err)
+	}
+	toolPath := filepath.Join(filepath.Dir(exe), name)
+	if _, err := os.Stat(toolPath); err != nil {
+		return "", fmt.Errorf("未找到 %s 工具: %v", name, err)
+	}
+	return toolPath, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// 单行最大长度，超过时拆成多行
+const maxLineSize = 1024 * 1024
+
+// 按行切分，支持 CRLF、LF 以及进度条常用的单独 CR
+// 超长的行按 maxLineSize 拆开（不拆开 UTF-8 字符），不会因为 ErrTooLong 停止读取
+func splitLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		// CR 在缓冲区末尾，需要下一个字节才能判断是否为 CRLF
+		if !atEOF {
+			return 0, nil, nil
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	if len(data) > maxLineSize {
+		n := maxLineSize
+		for k := n; k > n-utf8.UTFMax; k-- {
+			if utf8.RuneStart(data[k]) {
+				n = k
+				break
+			}
+		}
+		return n, data[:n], nil
+	}
+	return 0, nil, nil
+}
+
+// 从子进程管道逐行读取输出，按代码页解码为 UTF-8 后回调，空行忽略
+func scanLines(r io.Reader, cp uintptr, onLine func(line string)) error {
+	scanner := bufio.NewScanner(transform.NewReader(r, newAutoDecoder(cp)))
+	scanner.Buffer(make([]byte, 64*1024), 2*maxLineSize)
+	scanner.Split(splitLines)
+	for scanner.Scan() {
+		line := bytes.TrimRight(scanner.Bytes(), " \t")
+		if len(line) == 0 {
+			continue
+		}
+		onLine(string(line))
+	}
+	if err := scanner.Err(); err != nil {
+		// 读完剩下的输出，否则子进程写满管道后会一直阻塞，任务无法结束
+		io.Copy(io.Discard, r)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+)
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"LF", "a\nb\n", []string{"a", "b"}},
+		{"CRLF", "a\r\nb\r\n", []string{"a", "b"}},
+		{"CR", "10%\r20%\r100%\r", []string{"10%", "20%", "100%"}},
+		{"混合", "a\r\nb\nc\rd", []string{"a", "b", "c", "d"}},
+		{"末尾没有换行", "a\nlast", []string{"a", "last"}},
+		{"末尾的 CR", "a\r", []string{"a"}},
+		{"空行", "\n\r\n\r", []string{"", "", ""}},
+		{"CR CR LF", "a\r\r\nb", []string{"a", "", "b"}},
+		{"空输入", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 一次读一个字节，CRLF 会被拆在两次读取中
+			for _, r := range []io.Reader{strings.NewReader(tt.in), iotest.OneByteReader(strings.NewReader(tt.in))} {
+				s := bufio.NewScanner(r)
+				s.Split(splitLines)
+				var got []string
+				for s.Scan() {
+					got = append(got, s.Text())
+				}
+				if err := s.Err(); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %q，应为 %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// 超长的行拆开输出，之后的行照常读取
+func TestScanLinesLongLine(t *testing.T) {
+	long := strings.Repeat("补丁", maxLineSize/3)
+	in := "first\r\n" + long + "\r\nlast\r\n"
+	var lines []string
+	if err := scanLines(strings.NewReader(in), 65001, func(line string) { lines = append(lines, line) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) < 4 || lines[0] != "first" || lines[len(lines)-1] != "last" {
+		t.Fatalf("读到 %d 行", len(lines))
+	}
+	joined := strings.Join(lines[1:len(lines)-1], "")
+	if joined != long {
+		t.Fatalf("拆开的行拼起来与原行不一致 (%d / %d 字节)", len(joined), len(long))
+	}
+	for _, l := range lines {
+		if len(l) > maxLineSize || !utf8.ValidString(l) {
+			t.Fatalf("拆开的行长度 %d，UTF-8 合法: %v", len(l), utf8.ValidString(l))
+		}
+	}
+}
+
+// 读取出错后仍要读完管道
+func TestScanLinesDrainsOnError(t *testing.T) {
+	src := strings.NewReader("a\nb\n" + strings.Repeat("x", 1<<16))
+	err := scanLines(&errAfterReader{r: src}, 65001, func(string) {})
+	if err == nil {
+		t.Fatal("应返回读取错误")
+	}
+	if src.Len() != 0 {
+		t.Fatalf("出错后还有 %d 字节没有读取", src.Len())
+	}
+}
+
+// 第一次读取返回错误，之后正常读完
+type errAfterReader struct {
+	r     io.Reader
+	calls int
+}
+
+func (e *errAfterReader) Read(p []byte) (int, error) {
+	e.calls++
+	if e.calls == 1 {
+		return 0, io.ErrClosedPipe
+	}
+	return e.r.Read(p)
+}
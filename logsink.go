@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "调试"
+	case LevelWarn:
+		return "警告"
+	case LevelError:
+		return "错误"
+	default:
+		return "信息"
+	}
+}
+
+// 日志来源：程序自身的提示，或子进程的标准输出/错误输出
+type LogSource int
+
+const (
+	SourceApp LogSource = iota
+	SourceStdout
+	SourceStderr
+)
+
+func (s LogSource) String() string {
+	switch s {
+	case SourceStdout:
+		return "标准输出"
+	case SourceStderr:
+		return "错误输出"
+	default:
+		return "程序"
+	}
+}
+
+// 一条日志，JobID 为空表示不属于任何任务（页面自身的提示）
+type LogEntry struct {
+	Time   time.Time
+	Level  LogLevel
+	Source LogSource
+	JobID  string
+	Text   string
+}
+
+// 纯文本格式，用于日志文件和导出
+func (e LogEntry) String() string {
+	text := e.Text
+	switch e.Source {
+	case SourceStdout:
+		text = "[stdout] " + text
+	case SourceStderr:
+		text = "[stderr] " + text
+	}
+	if e.Level == LevelWarn || e.Level == LevelError {
+		text = e.Level.String() + ": " + text
+	}
+	return fmt.Sprintf("[%s] %s", e.Time.Format("15:04:05"), text)
+}
+
+// LogSink 收集一个任务（或一个页面）产生的日志，并分发给订阅者
+// 订阅时会先补发已有的日志，保证后订阅的视图也能看到完整内容
+type LogSink struct {
+	JobID string
+
+	mu      sync.Mutex
+	entries []LogEntry
+	subs    []func(LogEntry)
+}
+
+func newLogSink(jobID string) *LogSink {
+	return &LogSink{JobID: jobID}
+}
+
+func (s *LogSink) Subscribe(fn func(LogEntry)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		fn(e)
+	}
+	s.subs = append(s.subs, fn)
+}
+
+// 写入一条日志，多行文本拆成多条记录
+func (s *LogSink) Write(level LogLevel, source LogSource, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		e := LogEntry{Time: now, Level: level, Source: source, JobID: s.JobID, Text: line}
+		s.entries = append(s.entries, e)
+		for _, fn := range s.subs {
+			fn(e)
+		}
+	}
+}
+
+func (s *LogSink) Log(level LogLevel, text string) { s.Write(level, SourceApp, text) }
+
+func (s *LogSink) Info(text string)  { s.Log(LevelInfo, text) }
+func (s *LogSink) Warn(text string)  { s.Log(LevelWarn, text) }
+func (s *LogSink) Error(text string) { s.Log(LevelError, text) }
+
+func (s *LogSink) Infof(format string, a ...any)  { s.Log(LevelInfo, fmt.Sprintf(format, a...)) }
+func (s *LogSink) Warnf(format string, a ...any)  { s.Log(LevelWarn, fmt.Sprintf(format, a...)) }
+func (s *LogSink) Errorf(format string, a ...any) { s.Log(LevelError, fmt.Sprintf(format, a...)) }
+
+// 子进程输出，错误输出按错误级别记录
+func (s *LogSink) Stdout(line string) { s.Write(LevelInfo, SourceStdout, line) }
+func (s *LogSink) Stderr(line string) { s.Write(LevelError, SourceStderr, line) }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+// 日志表格的数据源，只保存通过筛选的记录
+type logTableModel struct {
+	walk.TableModelBase
+	rows []LogEntry
+}
+
+func (m *logTableModel) RowCount() int {
+	return len(m.rows)
+}
+
+func (m *logTableModel) Value(row, col int) interface{} {
+	e := m.rows[row]
+	switch col {
+	case 0:
+		return e.Time.Format("15:04:05")
+	case 1:
+		return e.Level.String()
+	case 2:
+		return e.Source.String()
+	case 3:
+		return e.JobID
+	default:
+		return e.Text
+	}
+}
+
+// LogView 显示订阅到的日志，支持按级别、来源筛选和文本搜索
+// entries 只在 UI 线程中读写
+type LogView struct {
+	mw *AppMainWindow
+
+	entries []LogEntry
+	model   *logTableModel
+
+	Table      *walk.TableView
+	LevelBox   *walk.ComboBox
+	SourceBox  *walk.ComboBox
+	SearchEdit *walk.LineEdit
+}
+
+func newLogView(mw *AppMainWindow) *LogView {
+	return &LogView{mw: mw, model: new(logTableModel)}
+}
+
+func (v *LogView) Attach(sink *LogSink) {
+	sink.Subscribe(v.add)
+}
+
+func (v *LogView) Widget() Widget {
+	return Composite{
+		Layout: VBox{MarginsZero: true},
+		Children: []Widget{
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					Label{Text: "级别:"},
+					ComboBox{
+						AssignTo:              &v.LevelBox,
+						Model:                 []string{"全部", "信息及以上", "警告及以上", "仅错误"},
+						CurrentIndex:          0,
+						OnCurrentIndexChanged: v.refresh,
+					},
+					Label{Text: "来源:"},
+					ComboBox{
+						AssignTo:              &v.SourceBox,
+						Model:                 []string{"全部", SourceApp.String(), SourceStdout.String(), SourceStderr.String()},
+						CurrentIndex:          0,
+						OnCurrentIndexChanged: v.refresh,
+					},
+					Label{Text: "搜索:"},
+					LineEdit{
+						AssignTo:      &v.SearchEdit,
+						OnTextChanged: v.refresh,
+					},
+				},
+			},
+			TableView{
+				AssignTo:            &v.Table,
+				Model:               v.model,
+				LastColumnStretched: true,
+				Columns: []TableViewColumn{
+					{Title: "时间", Width: 70},
+					{Title: "级别", Width: 50},
+					{Title: "来源", Width: 70},
+					{Title: "任务", Width: 130},
+					{Title: "内容"},
+				},
+				StyleCell: v.styleCell,
+			},
+		},
+	}
+}
+
+// 错误行红色，警告行橙色
+func (v *LogView) styleCell(style *walk.CellStyle) {
+	if style.Row() >= len(v.model.rows) {
+		return
+	}
+	switch v.model.rows[style.Row()].Level {
+	case LevelError:
+		style.TextColor = walk.RGB(200, 0, 0)
+	case LevelWarn:
+		style.TextColor = walk.RGB(190, 110, 0)
+	}
+}
+
+func (v *LogView) add(e LogEntry) {
+	// UI 更新必须在主线程执行
+	v.mw.Synchronize(func() {
+		v.entries = append(v.entries, e)
+		if !v.match(e) {
+			return
+		}
+		v.model.rows = append(v.model.rows, e)
+		row := len(v.model.rows) - 1
+		v.model.PublishRowsInserted(row, row)
+		if v.Table != nil {
+			v.Table.EnsureItemVisible(row)
+		}
+	})
+}
+
+func (v *LogView) match(e LogEntry) bool {
+	if v.LevelBox != nil && e.Level < LogLevel(v.LevelBox.CurrentIndex()) {
+		return false
+	}
+	if v.SourceBox != nil && v.SourceBox.CurrentIndex() > 0 && e.Source != LogSource(v.SourceBox.CurrentIndex()-1) {
+		return false
+	}
+	if v.SearchEdit != nil {
+		if q := strings.ToLower(strings.TrimSpace(v.SearchEdit.Text())); q != "" {
+			return strings.Contains(strings.ToLower(e.Text), q) || strings.Contains(strings.ToLower(e.JobID), q)
+		}
+	}
+	return true
+}
+
+// 筛选条件变化后重新生成表格内容
+func (v *LogView) refresh() {
+	v.model.rows = v.model.rows[:0]
+	for _, e := range v.entries {
+		if v.match(e) {
+			v.model.rows = append(v.model.rows, e)
+		}
+	}
+	v.model.PublishRowsReset()
+	if v.Table != nil && len(v.model.rows) > 0 {
+		v.Table.EnsureItemVisible(len(v.model.rows) - 1)
+	}
+}
+
+// 全部日志的纯文本，不受筛选影响
+func (v *LogView) Text() string {
+	var sb strings.Builder
+	for _, e := range v.entries {
+		sb.WriteString(e.String())
+		sb.WriteString("\r\n")
+	}
+	return sb.String()
+}
@@ -1,23 +1,22 @@
 package main
 
 import (
-	"bytes"
+	"crypto/ed25519"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 	"unsafe"
 
-	"golang.org/x/text/encoding/simplifiedchinese"
-	"golang.org/x/text/transform"
-
 	"github.com/lxn/walk"
 	. "github.com/lxn/walk/declarative"
 )
@@ -39,11 +38,37 @@ type PatchTab struct {
 	OutPutEdit         *walk.LineEdit
 	CreatePatchBtn     *walk.PushButton
 	VerifyPatchBtn     *walk.PushButton
+	SaveLogBtn         *walk.PushButton
+	CopyCmdBtn         *walk.PushButton
 	OverwriteCheck     *walk.CheckBox
 	CompressCheck      *walk.CheckBox
 	SkipVerifyCheck    *walk.CheckBox
 	MD5Check           *walk.CheckBox
-	LogTextEdit        *walk.TextEdit
+	ManifestCheck      *walk.CheckBox
+	ReverseCheck       *walk.CheckBox
+	NameTemplateEdit   *walk.LineEdit
+	FormatCombo        *walk.ComboBox
+	BackendCombo       *walk.ComboBox
+	MatchModeCombo     *walk.ComboBox
+	SFXCheck           *walk.CheckBox
+	SFXStubCombo       *walk.ComboBox
+	SFXStubEdit        *walk.LineEdit
+	SFXTargetEdit      *walk.LineEdit
+	BundleCheck        *walk.CheckBox
+	NotesEdit          *walk.LineEdit
+	SignCheck          *walk.CheckBox
+	SigningKeyEdit     *walk.LineEdit
+	EncryptCheck       *walk.CheckBox
+	EncryptModeCombo   *walk.ComboBox
+	EncryptPassEdit    *walk.LineEdit
+	EncryptKeysEdit    *walk.LineEdit
+	SplitCheck         *walk.CheckBox
+	SplitSizeEdit      *walk.NumberEdit
+	PreviewEdit        *walk.LineEdit
+	ExtraArgsEdit      *walk.LineEdit
+	DryRunCheck        *walk.CheckBox
+	Log                *LogSink
+	LogView            *LogView
 	SelectOldBtn       *walk.PushButton
 	SelectOldFolderBtn *walk.PushButton
 	SelectNewBtn       *walk.PushButton
@@ -63,16 +88,32 @@ type ApplyTab struct {
 	OldPathEdit        *walk.LineEdit
 	PatchPathEdit      *walk.LineEdit
 	OutPutEdit         *walk.LineEdit
+	RefPathEdit        *walk.LineEdit
 	ApplyPatchBtn      *walk.PushButton
 	VerifyApplyBtn     *walk.PushButton
+	SaveLogBtn         *walk.PushButton
+	CopyCmdBtn         *walk.PushButton
+	RollbackBtn        *walk.PushButton
 	OverwriteCheck     *walk.CheckBox
 	SkipVerifyCheck    *walk.CheckBox
-	LogTextEdit        *walk.TextEdit
+	InPlaceCheck       *walk.CheckBox
+	RequireSigCheck    *walk.CheckBox
+	TrustedLabel       *walk.Label
+	DecryptPassEdit    *walk.LineEdit
+	DecryptKeyEdit     *walk.LineEdit
+	BackendCombo       *walk.ComboBox
+	PreviewEdit        *walk.LineEdit
+	ExtraArgsEdit      *walk.LineEdit
+	DryRunCheck        *walk.CheckBox
+	Log                *LogSink
+	LogView            *LogView
 	SelectOldBtn       *walk.PushButton
 	SelectOldFolderBtn *walk.PushButton
 	SelectPatchBtn     *walk.PushButton
 	SelectNewBtn       *walk.PushButton
 	SelectNewFolderBtn *walk.PushButton
+	SelectRefBtn       *walk.PushButton
+	SelectRefFolderBtn *walk.PushButton
 	OldPathLabel       *walk.Label
 	PatchPathLabel     *walk.Label
 	NewPathLabel       *walk.Label
@@ -82,32 +123,70 @@ type ApplyTab struct {
 	ProgressBar        *walk.ProgressBar
 }
 
+// 诊断页
+type DoctorTab struct {
+	ReportEdit *walk.TextEdit
+	RefreshBtn *walk.PushButton
+	Report     *diagReport
+}
+
+type PlanTab struct {
+	TabPage           *walk.TabPage
+	RepoPathEdit      *walk.LineEdit
+	InstalledPathEdit *walk.LineEdit
+	TargetCombo       *walk.ComboBox
+	ScanBtn           *walk.PushButton
+	PlanBtn           *walk.PushButton
+	UsePlanBtn        *walk.PushButton
+	ExportDotBtn      *walk.PushButton
+	SaveLogBtn        *walk.PushButton
+	Log               *LogSink
+	LogView           *LogView
+	Graph             *versionGraph
+	Targets           []*versionNode
+	Current           string
+	Plan              []patchEdge
+	ProgressBar       *walk.ProgressBar
+}
+
 type AppMainWindow struct {
 	*walk.MainWindow
 	TabWidget *walk.TabWidget
 	PatchTab  *PatchTab
 	ApplyTab  *ApplyTab
-	LogMutex  sync.Mutex
+	PlanTab   *PlanTab
+	DoctorTab *DoctorTab
+	// 探测到的 hdiffz 版本和参数，为 nil 时不限制界面选项
+	Tools *toolCaps
 }
 
-func (mw *AppMainWindow) log(text string) {
-	mw.LogMutex.Lock()
-	defer mw.LogMutex.Unlock()
-	now := time.Now().Format("15:04:05")
-	logLine := fmt.Sprintf("[%s] %s\r\n", now, text)
-	curTab := mw.TabWidget.CurrentIndex()
-	// UI 更新必须在主线程执行
-	mw.Synchronize(func() {
-		var logEdit *walk.TextEdit
-		if curTab == 0 {
-			logEdit = mw.PatchTab.LogTextEdit
-		} else {
-			logEdit = mw.ApplyTab.LogTextEdit
-		}
-		if logEdit != nil {
-			logEdit.AppendText(logLine)
-		}
-	})
+// 页面序号
+const (
+	tabPatch = iota
+	tabApply
+	tabPlan
+	tabDoctor
+)
+
+// 页面自身的日志 sink
+func (mw *AppMainWindow) tabLog(tab int) *LogSink {
+	switch tab {
+	case tabPatch:
+		return mw.PatchTab.Log
+	case tabPlan:
+		return mw.PlanTab.Log
+	}
+	return mw.ApplyTab.Log
+}
+
+func (mw *AppMainWindow) tabLogView(tab int) *LogView {
+	switch tab {
+	case tabPatch:
+		return mw.PatchTab.LogView
+	case tabPlan:
+		return mw.PlanTab.LogView
+	}
+	return mw.ApplyTab.LogView
 }
 
 var md5Running sync.Mutex
@@ -132,15 +211,16 @@ func (mw *AppMainWindow) compare() {
 }
 
 func (mw *AppMainWindow) BenchmarkCompare(file1, file2 string) {
+	log := mw.PatchTab.Log
 	if !md5Running.TryLock() {
-		mw.log("MD5 校验正在进行中，请稍候...")
+		log.Info("MD5 校验正在进行中，请稍候...")
 		return
 	}
 
 	start := time.Now()
 	md5done := make(chan bool)
 
-	mw.log("计算文件MD5值...")
+	log.Info("计算文件MD5值...")
 	go func() {
 		defer func() {
 			md5Running.Unlock()
@@ -149,21 +229,21 @@ func (mw *AppMainWindow) BenchmarkCompare(file1, file2 string) {
 
 		hash1, err := fastMD5(file1)
 		if err != nil {
-			mw.log(fmt.Sprintf("错误: 无法获取文件信息 %s - %v", file1, err))
+			log.Errorf("无法获取文件信息 %s - %v", file1, err)
 			return
 		}
 		hash2, err := fastMD5(file2)
 		if err != nil {
-			mw.log(fmt.Sprintf("错误: 无法获取文件信息 %s - %v", file2, err))
+			log.Errorf("无法获取文件信息 %s - %v", file2, err)
 			return
 		}
 		elapsed := time.Since(start)
-		mw.log(fmt.Sprintf("耗时: %v", elapsed))
-		mw.log(fmt.Sprintf("MD5 计算结果:\r\nMD5: [%s]  %s\r\nMD5: [%s]  %s", hash1, filepath.Base(file1), hash2, filepath.Base(file2)))
+		log.Infof("耗时: %v", elapsed)
+		log.Infof("MD5 计算结果:\r\nMD5: [%s]  %s\r\nMD5: [%s]  %s", hash1, filepath.Base(file1), hash2, filepath.Base(file2))
 		if hash1 == hash2 {
-			mw.log("[旧文件] 和 [新文件] MD5 值相同")
+			log.Info("[旧文件] 和 [新文件] MD5 值相同")
 		} else {
-			mw.log("计算完成")
+			log.Info("计算完成")
 		}
 	}()
 	go func() { <-md5done }()
@@ -195,15 +275,10 @@ func fastMD5(filename string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// GBK -> UTF-8
-func GbkToUtf8(s []byte) ([]byte, error) {
-	reader := transform.NewReader(bytes.NewReader(s), simplifiedchinese.GBK.NewDecoder())
-	return io.ReadAll(reader)
-}
-
 func (mw *AppMainWindow) setProcessing(index int, status bool) {
 	mw.Synchronize(func() {
-		if index == 0 {
+		switch index {
+		case tabPatch:
 			if status {
 				mw.PatchTab.ProgressBar.SetVisible(true)
 				mw.PatchTab.CreatePatchBtn.SetEnabled(false)
@@ -213,109 +288,124 @@ func (mw *AppMainWindow) setProcessing(index int, status bool) {
 				mw.PatchTab.CreatePatchBtn.SetEnabled(true)
 				mw.PatchTab.VerifyPatchBtn.SetEnabled(true)
 			}
-		} else {
+		case tabPlan:
+			mw.PlanTab.ProgressBar.SetVisible(status)
+			mw.PlanTab.ScanBtn.SetEnabled(!status)
+			mw.PlanTab.PlanBtn.SetEnabled(!status)
+			mw.PlanTab.UsePlanBtn.SetEnabled(!status)
+		default:
 			if status {
 				mw.ApplyTab.ProgressBar.SetVisible(true)
 				mw.ApplyTab.ApplyPatchBtn.SetEnabled(false)
+				mw.ApplyTab.VerifyApplyBtn.SetEnabled(false)
+				mw.ApplyTab.RollbackBtn.SetEnabled(false)
 			} else {
 				mw.ApplyTab.ProgressBar.SetVisible(false)
 				mw.ApplyTab.ApplyPatchBtn.SetEnabled(true)
+				mw.ApplyTab.VerifyApplyBtn.SetEnabled(true)
+				mw.ApplyTab.RollbackBtn.SetEnabled(true)
 			}
 		}
 	})
 }
 
-func (mw *AppMainWindow) executeCommand(args []string) {
-	start := time.Now()
-	done := make(chan bool)
-	curtab := mw.TabWidget.CurrentIndex()
-	mw.setProcessing(curtab, true)
+// 在后台执行一个任务，任务期间显示进度条并禁用页面按钮
+func (mw *AppMainWindow) runJob(tab int, name string, fn func(j *job) error) {
+	j := newJob(tab)
+	mw.tabLogView(tab).Attach(j.Log)
+	if err := j.openLog(); err != nil {
+		j.Log.Warnf("无法创建任务日志文件 - %v", err)
+	}
+	j.Log.Infof("任务: %s (%s)", j.ID, name)
+	mw.setProcessing(tab, true)
 
-	// 将工作目录切换到可执行文件所在目录，保证双击启动时能找到同目录的 hdiffz.exe
-	exe, err := os.Executable()
+	go func() {
+		start := time.Now()
+		defer func() {
+			j.closeLog()
+			mw.setProcessing(tab, false)
+		}()
+		if err := fn(j); err != nil {
+			j.Log.Errorf("%s失败: %v", name, err)
+		} else {
+			j.Log.Infof("%s完成", name)
+		}
+		j.Log.Infof("耗时: %v", time.Since(start))
+	}()
+}
+
+// 运行 hdiffz 并等待结束，输出逐行写入任务日志，返回码非 0 时返回错误
+func (j *job) runTool(args []string) error {
+	toolPath, err := findTool("hdiffz.exe")
 	if err != nil {
-		mw.log(fmt.Sprintf("错误: 无法获取程序路径 - %v\r\n", err))
-		mw.setProcessing(curtab, false)
-		return
-	}
-	exeDir := filepath.Dir(exe)
-	if exeDir != "" {
-		_ = os.Chdir(exeDir)
+		return err
 	}
-	toolPath := filepath.Join(exeDir, "hdiffz.exe")
-	if _, err := os.Stat(toolPath); err != nil {
-		mw.log(fmt.Sprintf("错误: 未找到 hdiffz.exe 工具: %v\r\n", err))
-		mw.setProcessing(curtab, false)
-		return
+	if caps, err := toolCapabilities(toolPath); err != nil {
+		j.Log.Warnf("检测 hdiffz 版本失败 - %v", err)
+	} else if err := caps.Check(args); err != nil {
+		return err
 	}
+	// 将工作目录切换到可执行文件所在目录，保证双击启动时能找到同目录的 hdiffz.exe
+	_ = os.Chdir(filepath.Dir(toolPath))
+	return j.runExe(toolPath, args)
+}
 
-	go func() {
-		defer func() { done <- true }()
-
-		cmd := exec.Command(toolPath, args...)
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			HideWindow:    true,
-			CreationFlags: 0x08000000,
-		}
+// 运行任意程序（hpatchz、自解压程序等），输出处理与 runTool 相同
+func (j *job) runExe(toolPath string, args []string) error {
+	j.Log.Info("命令行: " + commandLine(toolPath, args))
 
-		mw.log("Processing...")
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			mw.log(fmt.Sprintf("错误: 创建输出管道失败 - %v\r\n", err))
-			return
-		}
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			mw.log(fmt.Sprintf("错误: 创建错误管道失败 - %v\r\n", err))
-			return
-		}
-		if err := cmd.Start(); err != nil {
-			mw.log(fmt.Sprintf("错误: 启动进程失败 - %v\r\n", err))
-			return
-		}
-		outputRaw, readErr := io.ReadAll(stdout)
-		if readErr != nil {
-			mw.log(fmt.Sprintf("警告: 读取标准输出不完整 - %v\r\n", readErr))
-		}
-		errorRaw, readErr := io.ReadAll(stderr)
-		if readErr != nil {
-			mw.log(fmt.Sprintf("警告: 读取错误输出不完整 - %v\r\n", readErr))
-		}
+	cmd := exec.Command(toolPath, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: 0x08000000,
+	}
 
-		var output []byte
-		var errorOutput []byte
-		if Cp == 936 {
-			output, _ = GbkToUtf8(outputRaw)
-			errorOutput, _ = GbkToUtf8(errorRaw)
-		} else {
-			output = outputRaw
-			errorOutput = errorRaw
+	j.Log.Info("Processing...")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("创建输出管道失败 - %v", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("创建错误管道失败 - %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("启动进程失败 - %v", err)
+	}
+	// 两个管道同时读取，避免一方缓冲区写满导致子进程阻塞
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := scanLines(stdout, Cp, j.Log.Stdout); err != nil {
+			j.Log.Warnf("读取标准输出不完整 - %v", err)
 		}
-
-		if err := cmd.Wait(); err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-					mw.log(fmt.Sprintf("进程退出，返回码: %d\r\n", status.ExitStatus()))
-				}
+	}()
+	go func() {
+		defer wg.Done()
+		existsHinted := false
+		err := scanLines(stderr, Cp, func(line string) {
+			j.Log.Stderr(line)
+			if !existsHinted && strings.Contains(line, "already exists") {
+				existsHinted = true
+				j.Log.Error("已存在同名文件，请检查路径是否正确或勾选覆盖同名文件(-f)")
 			}
+		})
+		if err != nil {
+			j.Log.Warnf("读取错误输出不完整 - %v", err)
 		}
-		elapsed := time.Since(start)
-		mw.log(fmt.Sprintf("耗时: %v\r\n", elapsed))
+	}()
+	wg.Wait()
 
-		if len(output) > 0 {
-			mw.log("\r\n================================== Info ==================================\r\n\r\n" + strings.TrimSpace(string(output)) + "\r\n=======================================================================")
-		}
-		if len(errorOutput) > 0 {
-			mw.log("\r\n================================= ERROR =================================\r\n\r\n" + strings.TrimSpace(string(errorOutput)) + "\r\n=======================================================================")
-			if bytes.Contains(errorOutput, []byte("already exists")) {
-				mw.log("错误: 已存在同名文件，请检查路径是否正确或勾选覆盖同名文件(-f)")
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				return fmt.Errorf("进程退出，返回码: %d", status.ExitStatus())
 			}
 		}
-	}()
-	go func() {
-		<-done
-		mw.setProcessing(curtab, false)
-	}()
+		return err
+	}
+	return nil
 }
 
 func getPathType(path string) FileType {
@@ -338,11 +428,8 @@ func (mw *AppMainWindow) updatePatchName() {
 	}
 
 	oldPath := mw.PatchTab.OldPathEdit.Text()
-	oldName := filepath.Base(oldPath)
-	ext := filepath.Ext(oldName)
-
-	baseName := strings.TrimSuffix(oldName, ext)
-	newName := baseName + "_patch.diff"
+	newPath := mw.PatchTab.NewPathEdit.Text()
+	newName := renderPatchName(mw.PatchTab.NameTemplateEdit.Text(), oldPath, newPath, DirectionForward, mw.patchFormat().Ext)
 
 	mw.PatchTab.AutoPatchName = newName
 	currentPatch := mw.PatchTab.OutPutEdit.Text()
@@ -416,43 +503,109 @@ func (mw *AppMainWindow) updateApplyPathLabels() {
 	}
 }
 
-func (mw *AppMainWindow) createPatch() {
-	oldPath := mw.PatchTab.OldPathEdit.Text()
-	newPath := mw.PatchTab.NewPathEdit.Text()
-	patchPath := mw.PatchTab.OutPutEdit.Text()
+// 读取补丁文件头，在补丁路径旁显示补丁类型
+func (mw *AppMainWindow) updateApplyPatchLabel() {
+	patches := splitPatchList(mw.ApplyTab.PatchPathEdit.Text())
+	if len(patches) == 0 {
+		mw.ApplyTab.PatchPathType = FileTypeUnknown
+		mw.ApplyTab.PatchPathLabel.SetText("")
+		return
+	}
+	header, err := readPatchHeader(patches[0])
+	if err != nil {
+		mw.ApplyTab.PatchPathType = FileTypeUnknown
+		mw.ApplyTab.PatchPathLabel.SetText("❓ 未知")
+		return
+	}
+	mw.ApplyTab.PatchPathType = header.Kind
+	if len(patches) > 1 {
+		mw.ApplyTab.PatchPathLabel.SetText(fmt.Sprintf("🔗 补丁链 (%d 个)", len(patches)))
+		return
+	}
+	mw.ApplyTab.PatchPathLabel.SetText(header.String())
+}
+
+// 根据生成补丁页的当前设置构建 hdiffz 参数
+func (mw *AppMainWindow) patchArgs() ([]string, error) {
+	oldPath := cleanPath(mw.PatchTab.OldPathEdit.Text())
+	newPath := cleanPath(mw.PatchTab.NewPathEdit.Text())
+	patchPath := cleanPath(mw.PatchTab.OutPutEdit.Text())
 
 	if oldPath == "" {
-		mw.log("错误: 请选择旧文件/文件夹路径")
-		return
+		return nil, errors.New("请选择旧文件/文件夹路径")
 	}
 	if newPath == "" {
-		mw.log("错误: 请选择新文件/文件夹路径")
-		return
+		return nil, errors.New("请选择新文件/文件夹路径")
 	}
 	if patchPath == "" {
-		mw.log("错误: 请指定补丁文件输出路径")
-		return
+		return nil, errors.New("请指定补丁文件输出路径")
 	}
 	if _, err := os.Stat(oldPath); os.IsNotExist(err) {
-		mw.log("错误: 旧路径不存在 - " + oldPath)
-		return
+		return nil, errors.New("旧路径不存在 - " + oldPath)
 	}
 	if _, err := os.Stat(newPath); os.IsNotExist(err) {
-		mw.log("错误: 新路径不存在 - " + newPath)
-		return
+		return nil, errors.New("新路径不存在 - " + newPath)
 	}
 	// 检查路径类型一致性
 	oldType := getPathType(oldPath)
 	newType := getPathType(newPath)
 
 	if oldType != FileTypeUnknown && newType != FileTypeUnknown && oldType != newType {
-		mw.log("错误: 旧路径和新路径必须是相同的类型（都是文件或都是文件夹）")
-		return
+		return nil, errors.New("旧路径和新路径必须是相同的类型（都是文件或都是文件夹）")
 	}
-	// 构建参数
-	args := []string{}
-	if mw.PatchTab.CompressCheck.Checked() {
-		args = append(args, "-c-zstd-21-24")
+	opts, err := mw.patchOptions(oldType)
+	if err != nil {
+		return nil, err
+	}
+	return newArgs(opts...).Path(oldPath, newPath, patchPath).Build()
+}
+
+func (mw *AppMainWindow) patchFormat() patchFormat {
+	if mw.PatchTab.FormatCombo == nil {
+		return patchFormats[0]
+	}
+	return patchFormatAt(mw.PatchTab.FormatCombo.CurrentIndex())
+}
+
+// 切换输出格式时同步压缩选项和补丁扩展名
+func (mw *AppMainWindow) updatePatchFormat() {
+	f := mw.patchFormat()
+	switch {
+	case f.Forced != "":
+		mw.PatchTab.CompressCheck.SetText("压缩 (" + f.Forced + ")")
+		mw.PatchTab.CompressCheck.SetChecked(true)
+	case f.Compress == "":
+		mw.PatchTab.CompressCheck.SetText("压缩 (不支持)")
+		mw.PatchTab.CompressCheck.SetChecked(false)
+	default:
+		mw.PatchTab.CompressCheck.SetText("压缩 (" + f.Compress + ")")
+	}
+	mw.PatchTab.CompressCheck.SetEnabled(f.Forced == "" && f.Compress != "")
+	// 当前 hdiffz 不支持的格式和压缩类型
+	if mw.diffBackend() == backendTool && mw.Tools != nil {
+		if f.Flag != "" && !mw.Tools.Has(f.Flag) {
+			mw.PatchTab.Log.Warnf("%s 不支持 %s 格式", mw.Tools, f.Name)
+			mw.PatchTab.FormatCombo.SetCurrentIndex(0)
+			return
+		}
+		if name := compressorOf(f.Compress); name != "" && !mw.Tools.HasCompressor(name) {
+			mw.PatchTab.CompressCheck.SetText("压缩 (" + name + " 不可用)")
+			mw.PatchTab.CompressCheck.SetChecked(false)
+			mw.PatchTab.CompressCheck.SetEnabled(false)
+		}
+	}
+	mw.updatePatchName()
+}
+
+// 生成补丁的选项参数（不含路径）
+func (mw *AppMainWindow) patchOptions(inputType FileType) ([]string, error) {
+	matchMode := 0
+	if mw.PatchTab.MatchModeCombo != nil {
+		matchMode = mw.PatchTab.MatchModeCombo.CurrentIndex()
+	}
+	args, err := mw.patchFormat().Args(mw.PatchTab.CompressCheck.Checked(), inputType, matchMode)
+	if err != nil {
+		return nil, err
 	}
 	if mw.PatchTab.OverwriteCheck.Checked() {
 		args = append(args, "-f")
@@ -460,16 +613,284 @@ func (mw *AppMainWindow) createPatch() {
 	if mw.PatchTab.SkipVerifyCheck.Checked() {
 		args = append(args, "-d")
 	}
-	// 添加路径参数
-	if oldPath != "" {
-		args = append(args, oldPath)
+	extra, err := mw.diffExtraArgs()
+	if err != nil {
+		return nil, err
+	}
+	return append(args, extra...), nil
+}
+
+// 一次生成任务要输出的一个补丁
+type patchOutput struct {
+	From, To  string
+	Path      string
+	Direction string
+}
+
+func (mw *AppMainWindow) createPatch() {
+	if _, err := mw.patchArgs(); err != nil {
+		mw.PatchTab.Log.Error(err.Error())
+		return
+	}
+	oldPath := cleanPath(mw.PatchTab.OldPathEdit.Text())
+	opts, _ := mw.patchOptions(getPathType(oldPath))
+	newPath := cleanPath(mw.PatchTab.NewPathEdit.Text())
+	patchPath := cleanPath(mw.PatchTab.OutPutEdit.Text())
+	withBundle := mw.PatchTab.BundleCheck.Checked()
+	notesPath := cleanPath(mw.PatchTab.NotesEdit.Text())
+	// 补丁包中总是带有清单
+	withManifest := mw.PatchTab.ManifestCheck.Checked() || withBundle
+	withReverse := mw.PatchTab.ReverseCheck.Checked()
+	overwrite := mw.PatchTab.OverwriteCheck.Checked()
+	backend := mw.diffBackend()
+	verify := !mw.PatchTab.SkipVerifyCheck.Checked()
+	withSFX := mw.PatchTab.SFXCheck.Checked()
+	stub := sfxStubAt(mw.PatchTab.SFXStubCombo.CurrentIndex())
+	stubPath := mw.PatchTab.SFXStubEdit.Text()
+	sfxTarget := strings.TrimSpace(mw.PatchTab.SFXTargetEdit.Text())
+	withSign := mw.PatchTab.SignCheck.Checked()
+	signingKey := mw.PatchTab.SigningKeyEdit.Text()
+	withSplit := mw.PatchTab.SplitCheck.Checked()
+	volumeSize := int64(mw.PatchTab.SplitSizeEdit.Value()) << 20
+	withEncrypt := mw.PatchTab.EncryptCheck.Checked()
+	var encOpts cryptOptions
+	if withEncrypt {
+		var err error
+		if encOpts, err = mw.encryptOptions(); err != nil {
+			mw.PatchTab.Log.Error(err.Error())
+			return
+		}
+		// 自解压程序中的补丁无法加密
+		if withSFX {
+			mw.PatchTab.Log.Error("加密补丁时不能同时生成自解压程序")
+			return
+		}
+	}
+
+	preflight := diffPreflight{
+		OldPath:   oldPath,
+		NewPath:   newPath,
+		PatchPath: patchPath,
+		MatchMode: mw.PatchTab.MatchModeCombo.CurrentIndex(),
+		Reverse:   withReverse,
+		Encrypt:   withEncrypt,
+		Bundle:    withBundle,
+		SFX:       withSFX,
+	}
+	outputs := []patchOutput{{From: oldPath, To: newPath, Path: patchPath, Direction: DirectionForward}}
+	if withReverse {
+		reversePath := reversePatchPath(patchPath, mw.PatchTab.NameTemplateEdit.Text(), oldPath, newPath)
+		outputs = append(outputs, patchOutput{From: newPath, To: oldPath, Path: reversePath, Direction: DirectionReverse})
+	}
+
+	// 签名、自解压和补丁包的设置在差分之前检查，避免生成补丁后才发现错误
+	checkPackaging := func(j *job) error {
+		if withSign {
+			if _, err := readSigningKey(signingKey); err != nil {
+				return fmt.Errorf("读取签名私钥失败 - %v", err)
+			}
+		}
+		if withSFX {
+			sfxPath := sfxPathFor(patchPath, stub)
+			if err := checkOutputTarget(sfxPath, overwrite); err != nil {
+				return err
+			}
+			resolved, err := stub.Resolve(stubPath)
+			if err != nil {
+				return err
+			}
+			if _, _, err := j.sfxCommand(patchPath, resolved, sfxPath); err != nil {
+				return err
+			}
+		}
+		if withBundle {
+			if notesPath != "" && getPathType(notesPath) != FileTypeFile {
+				return fmt.Errorf("发布说明文件不存在 - %s", notesPath)
+			}
+			if err := checkOutputTarget(bundlePathFor(patchPath), overwrite); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	args = append(args, newPath, patchPath)
 
-	mw.executeCommand(args)
-	for _, rec := range args {
-		mw.log("args: " + rec)
+	if mw.PatchTab.DryRunCheck.Checked() {
+		mw.runJob(tabPatch, "检查 (不执行)", func(j *job) error {
+			j.Backend = backend
+			if err := j.preflightDiff(preflight); err != nil {
+				return err
+			}
+			if err := checkPackaging(j); err != nil {
+				return err
+			}
+			for _, out := range outputs {
+				saved := out.Path
+				if withEncrypt {
+					saved += encExt
+				}
+				if err := checkOutputTarget(saved, overwrite); err != nil {
+					return err
+				}
+				if backend != backendTool {
+					continue
+				}
+				args, err := newArgs(opts...).Path(out.From, out.To, out.Path).Build()
+				if err != nil {
+					return err
+				}
+				if err := j.checkTool(args); err != nil {
+					return err
+				}
+			}
+			j.Log.Info("检查通过，未执行任何操作")
+			return nil
+		})
+		return
+	}
+
+	mw.runJob(tabPatch, "生成补丁", func(j *job) error {
+		j.Backend = backend
+		if err := j.preflightDiff(preflight); err != nil {
+			return err
+		}
+		if err := checkPackaging(j); err != nil {
+			return err
+		}
+		var priv ed25519.PrivateKey
+		if withSign {
+			var err error
+			if priv, err = loadSigningKey(signingKey); err != nil {
+				return fmt.Errorf("读取签名私钥失败 - %v", err)
+			}
+		}
+		// 加密时只保存 .enc 文件，清单仍按明文补丁记录
+		saved := make([]string, len(outputs))
+		for i, out := range outputs {
+			saved[i] = out.Path
+			if withEncrypt {
+				saved[i] += encExt
+			}
+			if err := checkOutputTarget(saved[i], overwrite); err != nil {
+				return err
+			}
+		}
+		// 先写到临时文件，hdiffz 成功（含自带的补丁检查）后再改名，失败时不会留下不完整的补丁
+		tmpPaths := make([]string, len(outputs))
+		for i, out := range outputs {
+			tmpPaths[i] = tempSibling(out.Path, j.ID)
+			defer os.RemoveAll(tmpPaths[i])
+			if out.Direction == DirectionReverse {
+				j.Log.Info("生成回退补丁 (新 -> 旧)...")
+			}
+			if err := j.diffFile(opts, out.From, out.To, tmpPaths[i], verify || withReverse); err != nil {
+				return err
+			}
+		}
+		// 同时生成回退补丁时，两个补丁都用 -t 再验证一次（内置引擎生成时已检查）
+		if withReverse && backend == backendTool {
+			for i, out := range outputs {
+				j.Log.Infof("验证%s补丁...", directionName(out.Direction))
+				args, err := newArgs("-t").Path(out.From, out.To, tmpPaths[i]).Build()
+				if err == nil {
+					err = j.runTool(args)
+				}
+				if err != nil {
+					return fmt.Errorf("%s补丁验证失败 - %v", directionName(out.Direction), err)
+				}
+			}
+		}
+		for i, out := range outputs {
+			src := tmpPaths[i]
+			if withEncrypt {
+				j.Log.Infof("加密%s补丁...", directionName(out.Direction))
+				src = tmpPaths[i] + encExt
+				defer os.Remove(src)
+				if err := encryptFile(tmpPaths[i], src, encOpts); err != nil {
+					return fmt.Errorf("加密失败 - %v", err)
+				}
+			}
+			if err := commitOutput(src, saved[i], overwrite); err != nil {
+				return err
+			}
+			j.Log.Infof("%s补丁已保存: %s", directionName(out.Direction), saved[i])
+			if priv != nil {
+				if err := signFile(priv, saved[i]); err != nil {
+					return fmt.Errorf("签名失败 - %v", err)
+				}
+				j.Log.Info("签名已保存: " + saved[i] + sigExt)
+			}
+		}
+		if withSFX {
+			if err := j.packageSFX(patchPath, oldPath, newPath, stub, stubPath, sfxTarget, overwrite); err != nil {
+				return err
+			}
+		}
+		// 最后分卷的文件: 补丁和补丁包
+		splitTargets := append([]string{}, saved...)
+		if withManifest {
+			j.Log.Info("生成校验清单...")
+			m, err := newManifest(oldPath, newPath)
+			if err != nil {
+				return fmt.Errorf("计算清单摘要失败 - %v", err)
+			}
+			for i, out := range outputs {
+				// 加密时明文补丁只保留在临时路径
+				plain := out.Path
+				if withEncrypt {
+					plain = tmpPaths[i]
+				}
+				if err := m.AddPatchAs(plain, filepath.Base(out.Path), out.Direction); err != nil {
+					return fmt.Errorf("计算补丁摘要失败 - %v", err)
+				}
+			}
+			manifestPath := manifestPathFor(patchPath)
+			if err := m.Save(manifestPath); err != nil {
+				return fmt.Errorf("保存清单失败 - %v", err)
+			}
+			j.Log.Info("清单已保存: " + manifestPath)
+			if priv != nil {
+				if err := signFile(priv, manifestPath); err != nil {
+					return fmt.Errorf("签名失败 - %v", err)
+				}
+			}
+			if withBundle {
+				j.Log.Info("生成补丁包...")
+				files := append([]string{manifestPath}, saved...)
+				bundlePath := bundlePathFor(patchPath)
+				if err := checkOutputTarget(bundlePath, overwrite); err != nil {
+					return err
+				}
+				tmp := tempSibling(bundlePath, j.ID)
+				defer os.Remove(tmp)
+				if err := writeBundle(tmp, files, notesPath, bundleSigner(priv)); err != nil {
+					return fmt.Errorf("生成补丁包失败 - %v", err)
+				}
+				if err := commitOutput(tmp, bundlePath, overwrite); err != nil {
+					return err
+				}
+				j.Log.Info("补丁包已保存: " + bundlePath)
+				splitTargets = append(splitTargets, bundlePath)
+			}
+		}
+		if !withSplit {
+			return nil
+		}
+		for _, path := range splitTargets {
+			idx, err := splitVolumes(path, volumeSize)
+			if err != nil {
+				return fmt.Errorf("分卷失败 - %v", err)
+			}
+			j.Log.Infof("%s 已分为 %d 卷，索引: %s", filepath.Base(path), len(idx.Parts), path+partsSuffix)
+		}
+		return nil
+	})
+}
+
+func directionName(direction string) string {
+	if direction == DirectionReverse {
+		return "回退"
 	}
+	return "正向"
 }
 
 func (mw *AppMainWindow) verifyPatch() {
@@ -477,36 +898,164 @@ func (mw *AppMainWindow) verifyPatch() {
 	newPath := mw.PatchTab.NewPathEdit.Text()
 	patchPath := mw.PatchTab.OutPutEdit.Text()
 
-	if oldPath == "" || newPath == "" || patchPath == "" {
-		mw.log("错误: 请填写所有必要的路径")
+	if cleanPath(oldPath) == "" || cleanPath(newPath) == "" || cleanPath(patchPath) == "" {
+		mw.PatchTab.Log.Error("请填写所有必要的路径")
+		return
+	}
+	args, err := newArgs("-t").Path(oldPath, newPath, patchPath).Build()
+	if err != nil {
+		mw.PatchTab.Log.Error(err.Error())
 		return
 	}
-	args := []string{"-t", oldPath, newPath, patchPath}
-	mw.executeCommand(args)
+	mw.runJob(tabPatch, "验证补丁", func(j *job) error { return j.runTool(args) })
 }
 
-func (mw *AppMainWindow) applyPatch() {
-	oldPath := mw.ApplyTab.OldPathEdit.Text()
-	patchPath := mw.ApplyTab.PatchPathEdit.Text()
-	newPath := mw.ApplyTab.OutPutEdit.Text()
+// 应用补丁页的输入，补丁路径可以是用 ; 分隔的补丁链
+func (mw *AppMainWindow) applyInputs() (oldPath string, patches []string, err error) {
+	oldPath = cleanPath(mw.ApplyTab.OldPathEdit.Text())
+	patches = splitPatchList(mw.ApplyTab.PatchPathEdit.Text())
+	if oldPath == "" || len(patches) == 0 {
+		return "", nil, errors.New("请选择旧文件和补丁文件路径")
+	}
+	return oldPath, patches, nil
+}
 
-	if oldPath == "" || patchPath == "" {
-		mw.log("错误: 请选择旧文件和补丁文件路径")
+// 按应用补丁页的后端、额外参数和解密设置运行任务，设置有误时只记录错误
+func (mw *AppMainWindow) runApplyJob(name string, fn func(j *job) error) {
+	backend := mw.applyBackend()
+	extra, err := mw.applyExtraArgs()
+	if err != nil {
+		mw.ApplyTab.Log.Error(err.Error())
 		return
 	}
-	if newPath == "" {
-		mw.log("错误: 请指定新文件输出路径")
+	dec, err := mw.decryptOptions()
+	if err != nil {
+		mw.ApplyTab.Log.Errorf("读取解密私钥失败 - %v", err)
 		return
 	}
-	// 构建参数
-	args := []string{}
-	args = append(args, "--patch")
+	mw.runJob(tabApply, name, func(j *job) error {
+		j.Decrypt = dec
+		j.Backend = backend
+		j.ExtraArgs = extra
+		return fn(j)
+	})
+}
+
+// 根据应用补丁页的当前设置构建 hdiffz 参数
+func (mw *AppMainWindow) applyArgs() ([]string, error) {
+	oldPath, patches, err := mw.applyInputs()
+	if err != nil {
+		return nil, err
+	}
+	newPath := cleanPath(mw.ApplyTab.OutPutEdit.Text())
+	if newPath == "" {
+		return nil, errors.New("请指定新文件输出路径")
+	}
+	if len(patches) > 1 {
+		return nil, fmt.Errorf("补丁链包含 %d 个补丁，无法用一条 hdiffz 命令表示", len(patches))
+	}
+	if isBundle(patches[0]) {
+		return nil, errors.New("补丁包需要先解包校验，无法用一条 hdiffz 命令表示")
+	}
+	if volumeBase(patches[0]) != "" {
+		return nil, errors.New("分卷补丁需要先校验拼接，无法用一条 hdiffz 命令表示")
+	}
+	extra, err := mw.applyExtraArgs()
+	if err != nil {
+		return nil, err
+	}
+	args := newArgs("--patch")
 	if mw.ApplyTab.OverwriteCheck.Checked() {
-		args = append(args, "-f")
+		args.Opt("-f")
+	}
+	return args.Opt(extra...).Path(oldPath, patches[0], newPath).Build()
+}
+
+func (mw *AppMainWindow) applyPatch() {
+	if mw.ApplyTab.DryRunCheck.Checked() {
+		mw.dryRunApply()
+		return
+	}
+	if mw.ApplyTab.InPlaceCheck.Checked() {
+		mw.applyInPlace()
+		return
+	}
+	oldPath, patches, err := mw.applyInputs()
+	if err != nil {
+		mw.ApplyTab.Log.Error(err.Error())
+		return
 	}
-	// 添加路径参数
-	args = append(args, oldPath, patchPath, newPath)
-	mw.executeCommand(args)
+	newPath := cleanPath(mw.ApplyTab.OutPutEdit.Text())
+	if newPath == "" {
+		mw.ApplyTab.Log.Error("请指定新文件输出路径")
+		return
+	}
+	overwrite := mw.ApplyTab.OverwriteCheck.Checked()
+
+	mw.runApplyJob("应用补丁", func(j *job) error {
+		if err := checkOutputTarget(newPath, overwrite); err != nil {
+			return err
+		}
+		if err := j.preflightApply(oldPath, patches, newPath, false); err != nil {
+			return err
+		}
+		// 先输出到临时路径，成功且校验通过后再替换目标，失败时不会留下不完整的新文件/文件夹
+		tmpPath := tempSibling(newPath, j.ID)
+		workDir := tmpPath + ".steps"
+		defer os.RemoveAll(tmpPath)
+		defer os.RemoveAll(workDir)
+		if _, err := j.applyChain(oldPath, patches, tmpPath, workDir, ""); err != nil {
+			return err
+		}
+		if err := commitOutput(tmpPath, newPath, overwrite); err != nil {
+			return err
+		}
+		j.Log.Info("已输出到: " + newPath)
+		return nil
+	})
+}
+
+// 复制与当前设置对应的完整 hdiffz 命令行
+func (mw *AppMainWindow) copyCommandLine(tab int) {
+	var args []string
+	var err error
+	if tab == tabPatch {
+		args, err = mw.patchArgs()
+	} else {
+		args, err = mw.applyArgs()
+	}
+	log := mw.tabLog(tab)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	toolPath, err := findTool("hdiffz.exe")
+	if err != nil {
+		toolPath = "hdiffz.exe"
+	}
+	cmdline := commandLine(toolPath, args)
+	if err := walk.Clipboard().SetText(cmdline); err != nil {
+		log.Errorf("无法写入剪贴板 - %v", err)
+		return
+	}
+	log.Info("已复制命令行: " + cmdline)
+}
+
+// 将页面日志保存到文件
+func (mw *AppMainWindow) saveLog(tab int) {
+	log := mw.tabLog(tab)
+	dlg := new(walk.FileDialog)
+	dlg.Title = "保存日志"
+	dlg.Filter = "日志文件 (*.log)|*.log|所有文件 (*.*)|*.*"
+	dlg.FilePath = "hdiffz-gui-" + time.Now().Format("20060102-150405") + ".log"
+	if ok, _ := dlg.ShowSave(mw.MainWindow); !ok || dlg.FilePath == "" {
+		return
+	}
+	if err := os.WriteFile(dlg.FilePath, []byte(mw.tabLogView(tab).Text()), 0644); err != nil {
+		log.Errorf("保存日志失败 - %v", err)
+		return
+	}
+	log.Info("日志已保存: " + dlg.FilePath)
 }
 
 func (mw *AppMainWindow) selectFile(edit *walk.LineEdit, title, filter string) {
@@ -561,7 +1110,7 @@ func (mw *AppMainWindow) selectFolder(edit *walk.LineEdit, title string) {
 	ok, _, _ := procSHGetPathFromIDList.Call(pidl, uintptr(unsafe.Pointer(&pathBuf[0])))
 	if ok == 0 {
 		procCoTaskMemFree.Call(pidl)
-		mw.log("错误: 无法从 IDList 获取路径")
+		mw.tabLog(mw.TabWidget.CurrentIndex()).Error("无法从 IDList 获取路径")
 		return
 	}
 
@@ -615,7 +1164,8 @@ func (mw *AppMainWindow) handleDropFiles(files []string) {
 				return pt.X >= r.Left && pt.X <= r.Right && pt.Y >= r.Top && pt.Y <= r.Bottom
 			}
 
-			if currentIndex == 0 {
+			switch currentIndex {
+			case tabPatch:
 				if isPointInWindow(mw.PatchTab.OldPathEdit) {
 					mw.PatchTab.OldPathEdit.SetText(path)
 					fmt.Printf("拖放文件: %s -> 旧路径\r\n", path)
@@ -631,14 +1181,28 @@ func (mw *AppMainWindow) handleDropFiles(files []string) {
 					fmt.Printf("拖放文件: %s -> 补丁路径\r\n", path)
 					return
 				}
-			} else {
+			case tabPlan:
+				if isPointInWindow(mw.PlanTab.RepoPathEdit) {
+					mw.PlanTab.RepoPathEdit.SetText(path)
+					fmt.Printf("拖放文件: %s -> 补丁仓库\r\n", path)
+					return
+				}
+				if isPointInWindow(mw.PlanTab.InstalledPathEdit) {
+					mw.PlanTab.InstalledPathEdit.SetText(path)
+					fmt.Printf("拖放文件: %s -> 已安装版本\r\n", path)
+					return
+				}
+			default:
 				if isPointInWindow(mw.ApplyTab.OldPathEdit) {
 					mw.ApplyTab.OldPathEdit.SetText(path)
 					fmt.Printf("拖放文件: %s -> 旧路径\r\n", path)
 					return
 				}
 				if isPointInWindow(mw.ApplyTab.PatchPathEdit) {
-					mw.ApplyTab.PatchPathEdit.SetText(path)
+					// 拖入多个补丁时按文件名顺序组成补丁链
+					patches := append([]string{}, files...)
+					sort.Strings(patches)
+					mw.ApplyTab.PatchPathEdit.SetText(joinPatchList(patches))
 					fmt.Printf("拖放文件: %s -> 补丁路径\r\n", path)
 					return
 				}
@@ -654,14 +1218,39 @@ func (mw *AppMainWindow) handleDropFiles(files []string) {
 
 func main() {
 	// 创建窗口实例
-	mw := &AppMainWindow{}
-	mw.PatchTab = &PatchTab{}
-	mw.ApplyTab = &ApplyTab{}
+	mw := &AppMainWindow{DoctorTab: &DoctorTab{}}
+	mw.PatchTab = &PatchTab{Log: newLogSink(""), LogView: newLogView(mw)}
+	mw.ApplyTab = &ApplyTab{Log: newLogSink(""), LogView: newLogView(mw)}
+	mw.PatchTab.LogView.Attach(mw.PatchTab.Log)
+	mw.ApplyTab.LogView.Attach(mw.ApplyTab.Log)
+	mw.PlanTab = &PlanTab{Log: newLogSink(""), LogView: newLogView(mw)}
+	mw.PlanTab.LogView.Attach(mw.PlanTab.Log)
+
+	cfg, cfgErr := loadSettings()
+	if cfgErr != nil {
+		mw.ApplyTab.Log.Errorf("读取设置失败 - %v (修复或删除设置文件前不会保存设置，也无法检查签名、应用补丁)", cfgErr)
+	}
+	backend, toolErr := defaultBackend()
+	if toolErr != nil {
+		mw.PatchTab.Log.Warnf("%v，默认使用内置差分引擎", toolErr)
+		mw.ApplyTab.Log.Warnf("%v，默认使用内置应用器", toolErr)
+	}
+	// 内置差分引擎只生成 BSDIFF40，不支持流式匹配
+	builtinDiff := backend == backendGo
+	format := 0
+	if builtinDiff {
+		format = formatIndex("-BSD")
+	}
 
 	// ========== 获取系统默认ANSI编码 ==========
 	kernel32 := syscall.NewLazyDLL("kernel32.dll")
 	procGetACP := kernel32.NewProc("GetACP")
 	Cp, _, _ = procGetACP.Call()
+
+	// doctor 的报告写到标准输出，之前不能有其他输出
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(doctorCLI(os.Args[2:]))
+	}
 	fmt.Println("console_cp:", Cp)
 
 	// 创建主窗口
@@ -674,6 +1263,12 @@ func main() {
 		Children: []Widget{
 			TabWidget{
 				AssignTo: &mw.TabWidget,
+				OnCurrentIndexChanged: func() {
+					// 第一次打开诊断页时自动检测
+					if mw.TabWidget.CurrentIndex() == tabDoctor && mw.DoctorTab.Report == nil && mw.DoctorTab.RefreshBtn.Enabled() {
+						mw.refreshDiagnostics()
+					}
+				},
 				Pages: []TabPage{
 					{
 						Title:      "生成补丁",
@@ -745,6 +1340,45 @@ func main() {
 										},
 									},
 									Label{AssignTo: &mw.PatchTab.PatchPathLabel, Text: ""},
+
+									Label{Text: "命名模板:"},
+									LineEdit{
+										AssignTo:      &mw.PatchTab.NameTemplateEdit,
+										Text:          defaultNameTemplate,
+										ToolTipText:   "{old}/{new}: 旧/新文件名(不含扩展名)  {dir}: 方向标记(回退补丁为 _reverse)  {ext}: 扩展名",
+										OnTextChanged: func() { mw.updatePatchName() },
+									},
+									Label{Text: "{old} {new} {dir} {ext}"},
+									Label{Text: ""},
+
+									Label{Text: "输出格式:"},
+									ComboBox{
+										AssignTo:              &mw.PatchTab.FormatCombo,
+										Model:                 formatNames(),
+										CurrentIndex:          format,
+										Enabled:               !builtinDiff,
+										ToolTipText:           "-SD/-BSD/-VCD 仅支持单个文件；bsdiff4 固定使用 bzip2 压缩，VCDIFF 不支持 -c 压缩",
+										OnCurrentIndexChanged: func() { mw.updatePatchFormat() },
+									},
+									ComboBox{
+										AssignTo:     &mw.PatchTab.MatchModeCombo,
+										Model:        matchModeNames(),
+										CurrentIndex: 0,
+										Enabled:      !builtinDiff,
+										ToolTipText:  "流式匹配 (-s) 内存占用小，但补丁通常更大",
+									},
+									Label{Text: ""},
+
+									Label{Text: "差分引擎:"},
+									ComboBox{
+										AssignTo:              &mw.PatchTab.BackendCombo,
+										Model:                 diffBackendNames,
+										CurrentIndex:          backend,
+										ToolTipText:           "内置 bsdiff 不需要 hdiffz，只支持单个文件，需要把新旧文件读入内存",
+										OnCurrentIndexChanged: func() { mw.updateDiffBackend() },
+									},
+									Label{Text: ""},
+									Label{Text: ""},
 								},
 							},
 							Composite{
@@ -770,6 +1404,166 @@ func main() {
 										Text:     "对新旧文件进行MD5校验",
 										Checked:  true,
 									},
+									CheckBox{
+										AssignTo: &mw.PatchTab.ManifestCheck,
+										Text:     "生成校验清单",
+										Checked:  true,
+									},
+									CheckBox{
+										AssignTo: &mw.PatchTab.ReverseCheck,
+										Text:     "同时生成回退补丁 (新→旧)",
+										Checked:  false,
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									CheckBox{
+										AssignTo: &mw.PatchTab.SFXCheck,
+										Text:     "生成自解压程序 (SFX)",
+										Checked:  false,
+									},
+									ComboBox{
+										AssignTo:     &mw.PatchTab.SFXStubCombo,
+										Model:        sfxStubNames(),
+										CurrentIndex: 0,
+									},
+									LineEdit{
+										AssignTo:    &mw.PatchTab.SFXStubEdit,
+										ToolTipText: "hpatchz 存根程序，留空时使用本程序目录下的 hpatchz.exe / hpatchz",
+									},
+									PushButton{
+										Text: "存根...",
+										OnClicked: func() {
+											mw.selectFile(mw.PatchTab.SFXStubEdit, "选择 hpatchz 存根", "所有文件 (*.*)|*.*")
+										},
+									},
+									Label{Text: "默认目标目录:"},
+									LineEdit{
+										AssignTo:    &mw.PatchTab.SFXTargetEdit,
+										ToolTipText: "留空时自解压程序更新其所在目录；填写后额外生成启动脚本，更新该目录",
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									CheckBox{
+										AssignTo: &mw.PatchTab.BundleCheck,
+										Text:     "同时生成补丁包 (" + bundleExt + "，含清单和 " + bundleSums + ")",
+										Checked:  false,
+									},
+									Label{Text: "发布说明(可选):"},
+									LineEdit{AssignTo: &mw.PatchTab.NotesEdit},
+									PushButton{
+										Text: "选择...",
+										OnClicked: func() {
+											mw.selectFile(mw.PatchTab.NotesEdit, "选择发布说明", "文本文件 (*.txt;*.md)|*.txt;*.md|所有文件 (*.*)|*.*")
+										},
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									CheckBox{
+										AssignTo: &mw.PatchTab.SignCheck,
+										Text:     "签名 (Ed25519)",
+										Checked:  cfg.SigningKey != "",
+									},
+									Label{Text: "私钥:"},
+									LineEdit{AssignTo: &mw.PatchTab.SigningKeyEdit, Text: cfg.SigningKey},
+									PushButton{
+										Text: "选择...",
+										OnClicked: func() {
+											mw.selectFile(mw.PatchTab.SigningKeyEdit, "选择签名私钥", "私钥 (*.key)|*.key|所有文件 (*.*)|*.*")
+										},
+									},
+									PushButton{
+										Text:      "生成密钥...",
+										OnClicked: func() { mw.generateSigningKey() },
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									CheckBox{
+										AssignTo: &mw.PatchTab.EncryptCheck,
+										Text:     "加密补丁 (AES-GCM)",
+										Checked:  false,
+									},
+									ComboBox{
+										AssignTo:     &mw.PatchTab.EncryptModeCombo,
+										Model:        []string{"口令 (scrypt)", "接收者公钥 (X25519)"},
+										CurrentIndex: encPassphrase,
+									},
+									Label{Text: "口令:"},
+									LineEdit{AssignTo: &mw.PatchTab.EncryptPassEdit, PasswordMode: true},
+									Label{Text: "接收者公钥:"},
+									LineEdit{
+										AssignTo:    &mw.PatchTab.EncryptKeysEdit,
+										ToolTipText: "多个公钥用 ; 分隔",
+									},
+									PushButton{
+										Text: "选择...",
+										OnClicked: func() {
+											mw.selectPatchFiles(mw.PatchTab.EncryptKeysEdit)
+										},
+									},
+									PushButton{
+										Text:      "生成密钥...",
+										OnClicked: func() { mw.generateEncryptionKey() },
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									CheckBox{
+										AssignTo: &mw.PatchTab.SplitCheck,
+										Text:     "分卷 (.001/.002...，附校验索引)",
+										Checked:  false,
+									},
+									Label{Text: "每卷大小:"},
+									NumberEdit{
+										AssignTo: &mw.PatchTab.SplitSizeEdit,
+										Value:    1024,
+										MinValue: 1,
+										MaxValue: 1 << 20,
+										Suffix:   " MB",
+									},
+									HSpacer{},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									Label{Text: "额外参数:"},
+									LineEdit{
+										AssignTo:    &mw.PatchTab.ExtraArgsEdit,
+										ToolTipText: "附加到 hdiffz 命令的高级选项，不能与界面中已有的选项重复",
+									},
+									CheckBox{
+										AssignTo: &mw.PatchTab.DryRunCheck,
+										Text:     "仅检查 (不执行)",
+										Checked:  false,
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									Label{Text: "命令预览:"},
+									LineEdit{
+										AssignTo:    &mw.PatchTab.PreviewEdit,
+										ToolTipText: "随选项实时更新，可以编辑后点击\"按预览设置\"",
+									},
+									PushButton{
+										Text:      "按预览设置",
+										OnClicked: func() { mw.applyPreview(tabPatch) },
+									},
 								},
 							},
 							Composite{
@@ -785,15 +1579,23 @@ func main() {
 										Text:      "验证",
 										OnClicked: func() { mw.verifyPatch() },
 									},
+									PushButton{
+										AssignTo:  &mw.PatchTab.CopyCmdBtn,
+										Text:      "复制命令行",
+										OnClicked: func() { mw.copyCommandLine(tabPatch) },
+									},
+									PushButton{
+										Text:      "导入命令行...",
+										OnClicked: func() { mw.importCommand(tabPatch) },
+									},
+									PushButton{
+										AssignTo:  &mw.PatchTab.SaveLogBtn,
+										Text:      "保存日志...",
+										OnClicked: func() { mw.saveLog(tabPatch) },
+									},
 								},
 							},
-							TextEdit{
-								AssignTo:      &mw.PatchTab.LogTextEdit,
-								ReadOnly:      true,
-								HScroll:       true,
-								VScroll:       true,
-								OnTextChanged: func() { mw.PatchTab.LogTextEdit.SendMessage(0x0115, 7, 0) },
-							},
+							mw.PatchTab.LogView.Widget(),
 							ProgressBar{
 								AssignTo:    &mw.PatchTab.ProgressBar,
 								Visible:     false,
@@ -836,14 +1638,17 @@ func main() {
 
 									Label{Text: "补丁文件:"},
 									LineEdit{
-										AssignTo:      &mw.ApplyTab.PatchPathEdit,
-										OnTextChanged: func() { mw.updateApplyName() },
+										AssignTo: &mw.ApplyTab.PatchPathEdit,
+										OnTextChanged: func() {
+											mw.updateApplyName()
+											mw.updateApplyPatchLabel()
+										},
 									},
 									PushButton{
 										AssignTo: &mw.ApplyTab.SelectPatchBtn,
 										Text:     "选择...",
 										OnClicked: func() {
-											mw.selectFile(mw.ApplyTab.PatchPathEdit, "选择补丁文件", "全部文件(*.*)|*.*|补丁文件 (*.diff)|*.diff")
+											mw.selectPatchFiles(mw.ApplyTab.PatchPathEdit)
 										},
 									},
 									Label{AssignTo: &mw.ApplyTab.PatchPathLabel, Text: ""},
@@ -869,6 +1674,25 @@ func main() {
 										},
 									},
 									Label{AssignTo: &mw.ApplyTab.NewPathLabel, Text: ""},
+
+									Label{Text: "参考(可选):"},
+									LineEdit{AssignTo: &mw.ApplyTab.RefPathEdit},
+									Composite{
+										Layout: HBox{MarginsZero: true, SpacingZero: true},
+										Children: []Widget{
+											PushButton{
+												AssignTo:  &mw.ApplyTab.SelectRefBtn,
+												Text:      "文件...",
+												OnClicked: func() { mw.selectFile(mw.ApplyTab.RefPathEdit, "选择参考文件", "所有文件 (*.*)|*.*") },
+											},
+											PushButton{
+												AssignTo:  &mw.ApplyTab.SelectRefFolderBtn,
+												Text:      "文件夹...",
+												OnClicked: func() { mw.selectFolder(mw.ApplyTab.RefPathEdit, "选择参考文件夹") },
+											},
+										},
+									},
+									Label{Text: ""},
 								},
 							},
 							Composite{
@@ -879,6 +1703,82 @@ func main() {
 										Text:     "覆盖同名文件 (-f)",
 										Checked:  false,
 									},
+									CheckBox{
+										AssignTo: &mw.ApplyTab.InPlaceCheck,
+										Text:     "就地更新旧文件/文件夹 (自动备份，失败回滚)",
+										Checked:  false,
+										OnCheckedChanged: func() {
+											mw.ApplyTab.OutPutEdit.SetEnabled(!mw.ApplyTab.InPlaceCheck.Checked())
+										},
+									},
+									CheckBox{
+										AssignTo: &mw.ApplyTab.RequireSigCheck,
+										Text:     "必须验证签名",
+										Checked:  cfg.RequireSignature,
+										OnCheckedChanged: func() {
+											mw.setRequireSignature(mw.ApplyTab.RequireSigCheck.Checked())
+										},
+									},
+									Label{
+										AssignTo:    &mw.ApplyTab.TrustedLabel,
+										Text:        fmt.Sprintf("已信任 %d 个公钥", len(cfg.TrustedKeys)),
+										ToolTipText: "信任列表保存在设置文件 settings.json 中",
+									},
+									PushButton{
+										Text:      "信任公钥...",
+										OnClicked: func() { mw.selectTrustedKey() },
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									Label{Text: "应用方式:"},
+									ComboBox{
+										AssignTo:     &mw.ApplyTab.BackendCombo,
+										Model:        backendNames,
+										CurrentIndex: backend,
+										ToolTipText:  "内置应用器不需要 hdiffz，支持未压缩及 zstd 压缩的单文件补丁",
+									},
+									Label{Text: "解密口令:"},
+									LineEdit{AssignTo: &mw.ApplyTab.DecryptPassEdit, PasswordMode: true},
+									Label{Text: "解密私钥:"},
+									LineEdit{AssignTo: &mw.ApplyTab.DecryptKeyEdit},
+									PushButton{
+										Text: "选择...",
+										OnClicked: func() {
+											mw.selectFile(mw.ApplyTab.DecryptKeyEdit, "选择解密私钥", "私钥 (*.key)|*.key|所有文件 (*.*)|*.*")
+										},
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									Label{Text: "额外参数:"},
+									LineEdit{
+										AssignTo:    &mw.ApplyTab.ExtraArgsEdit,
+										ToolTipText: "附加到 hdiffz 命令的高级选项，不能与界面中已有的选项重复",
+									},
+									CheckBox{
+										AssignTo: &mw.ApplyTab.DryRunCheck,
+										Text:     "仅检查 (不执行)",
+										Checked:  false,
+									},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									Label{Text: "命令预览:"},
+									LineEdit{
+										AssignTo:    &mw.ApplyTab.PreviewEdit,
+										ToolTipText: "随选项实时更新，可以编辑后点击\"按预览设置\"",
+									},
+									PushButton{
+										Text:      "按预览设置",
+										OnClicked: func() { mw.applyPreview(tabApply) },
+									},
 								},
 							},
 							Composite{
@@ -889,15 +1789,33 @@ func main() {
 										Text:      "应用补丁",
 										OnClicked: func() { mw.applyPatch() },
 									},
+									PushButton{
+										AssignTo:  &mw.ApplyTab.VerifyApplyBtn,
+										Text:      "验证",
+										OnClicked: func() { mw.verifyApply() },
+									},
+									PushButton{
+										AssignTo:  &mw.ApplyTab.RollbackBtn,
+										Text:      "回滚上次更新",
+										OnClicked: func() { mw.rollbackLastApply() },
+									},
+									PushButton{
+										AssignTo:  &mw.ApplyTab.CopyCmdBtn,
+										Text:      "复制命令行",
+										OnClicked: func() { mw.copyCommandLine(tabApply) },
+									},
+									PushButton{
+										Text:      "导入命令行...",
+										OnClicked: func() { mw.importCommand(tabApply) },
+									},
+									PushButton{
+										AssignTo:  &mw.ApplyTab.SaveLogBtn,
+										Text:      "保存日志...",
+										OnClicked: func() { mw.saveLog(tabApply) },
+									},
 								},
 							},
-							TextEdit{
-								AssignTo:      &mw.ApplyTab.LogTextEdit,
-								ReadOnly:      true,
-								HScroll:       true,
-								VScroll:       true,
-								OnTextChanged: func() { mw.ApplyTab.LogTextEdit.SendMessage(0x0115, 7, 0) },
-							},
+							mw.ApplyTab.LogView.Widget(),
 							ProgressBar{
 								AssignTo:    &mw.ApplyTab.ProgressBar,
 								Visible:     false,
@@ -905,13 +1823,129 @@ func main() {
 							},
 						},
 					},
+
+					{
+						Title:  "更新路径",
+						Layout: VBox{},
+						Children: []Widget{
+							Composite{
+								Layout: Grid{Columns: 3, Spacing: 10},
+								Children: []Widget{
+									Label{Text: "补丁仓库:"},
+									LineEdit{AssignTo: &mw.PlanTab.RepoPathEdit},
+									PushButton{
+										Text:      "文件夹...",
+										OnClicked: func() { mw.selectFolder(mw.PlanTab.RepoPathEdit, "选择补丁仓库文件夹") },
+									},
+
+									Label{Text: "已安装版本:"},
+									LineEdit{AssignTo: &mw.PlanTab.InstalledPathEdit},
+									Composite{
+										Layout: HBox{MarginsZero: true, SpacingZero: true},
+										Children: []Widget{
+											PushButton{
+												Text: "文件...",
+												OnClicked: func() {
+													mw.selectFile(mw.PlanTab.InstalledPathEdit, "选择已安装的文件", "所有文件 (*.*)|*.*")
+												},
+											},
+											PushButton{
+												Text:      "文件夹...",
+												OnClicked: func() { mw.selectFolder(mw.PlanTab.InstalledPathEdit, "选择已安装的文件夹") },
+											},
+										},
+									},
+
+									Label{Text: "目标版本:"},
+									ComboBox{AssignTo: &mw.PlanTab.TargetCombo, Editable: false},
+									HSpacer{},
+								},
+							},
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									PushButton{
+										AssignTo:  &mw.PlanTab.ScanBtn,
+										Text:      "扫描仓库",
+										OnClicked: func() { mw.scanRepo() },
+									},
+									PushButton{
+										AssignTo:  &mw.PlanTab.PlanBtn,
+										Text:      "计算更新路径",
+										OnClicked: func() { mw.planUpdate() },
+									},
+									PushButton{
+										AssignTo:  &mw.PlanTab.UsePlanBtn,
+										Text:      "发送到应用补丁",
+										OnClicked: func() { mw.usePlan() },
+									},
+									PushButton{
+										AssignTo:  &mw.PlanTab.ExportDotBtn,
+										Text:      "导出版本图 (DOT)...",
+										OnClicked: func() { mw.exportDOT() },
+									},
+									PushButton{
+										AssignTo:  &mw.PlanTab.SaveLogBtn,
+										Text:      "保存日志...",
+										OnClicked: func() { mw.saveLog(tabPlan) },
+									},
+								},
+							},
+							mw.PlanTab.LogView.Widget(),
+							ProgressBar{
+								AssignTo:    &mw.PlanTab.ProgressBar,
+								Visible:     false,
+								MarqueeMode: true,
+							},
+						},
+					},
+					{
+						Title:  "诊断",
+						Layout: VBox{},
+						Children: []Widget{
+							Composite{
+								Layout: HBox{},
+								Children: []Widget{
+									PushButton{
+										AssignTo:  &mw.DoctorTab.RefreshBtn,
+										Text:      "重新检测",
+										OnClicked: func() { mw.refreshDiagnostics() },
+									},
+									PushButton{
+										Text:      "导出报告...",
+										OnClicked: func() { mw.exportDiagnostics() },
+									},
+									PushButton{
+										Text:      "复制",
+										OnClicked: func() { mw.copyDiagnostics() },
+									},
+									HSpacer{},
+								},
+							},
+							TextEdit{
+								AssignTo: &mw.DoctorTab.ReportEdit,
+								ReadOnly: true,
+								VScroll:  true,
+								HScroll:  true,
+								Font:     Font{Family: "Consolas", PointSize: 9},
+							},
+						},
+					},
 				},
 			},
 		},
 		OnDropFiles: func(files []string) { mw.handleDropFiles(files) },
 	}
 
+	// 先创建窗口再后台检测工具，检测结果通过 Synchronize 更新界面
+	if err := w.Create(); err != nil {
+		fmt.Println("Create() error:", err)
+		return
+	}
+	mw.updateDiffBackend()
+	mw.watchPreview()
+	go mw.detectTools()
 	fmt.Println("Starting Run()...")
-	ret, err := w.Run()
-	fmt.Println("Run() returned code:", ret, "error:>>>", err, "<<<")
+	ret := mw.MainWindow.Run()
+	fmt.Println("Run() returned code:", ret)
 }
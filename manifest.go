@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 清单文件名后缀，与补丁放在同一目录: xxx_patch.diff -> xxx_patch.manifest.json
+const manifestSuffix = ".manifest.json"
+
+const (
+	DirectionForward = "forward" // 旧 -> 新
+	DirectionReverse = "reverse" // 新 -> 旧
+)
+
+// 文件或文件夹的摘要，文件夹的 SHA256 由所有相对路径和文件内容摘要计算得出
+type PathDigest struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// 清单中的一个补丁文件
+type PatchEntry struct {
+	File      string `json:"file"`
+	Direction string `json:"direction"`
+	Format    string `json:"format,omitempty"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+// 补丁清单，记录新旧数据和补丁本身的摘要，用于应用后校验结果
+type PatchManifest struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"created_at"`
+	Old       PathDigest   `json:"old"`
+	New       PathDigest   `json:"new"`
+	Patches   []PatchEntry `json:"patches"`
+}
+
+func manifestPathFor(patchPath string) string {
+	return strings.TrimSuffix(patchPath, filepath.Ext(patchPath)) + manifestSuffix
+}
+
+func newManifest(oldPath, newPath string) (*PatchManifest, error) {
+	oldDigest, err := digestPath(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newDigest, err := digestPath(newPath)
+	if err != nil {
+		return nil, err
+	}
+	return &PatchManifest{Version: 1, CreatedAt: time.Now(), Old: oldDigest, New: newDigest}, nil
+}
+
+// 记录补丁，name 为清单中的文件名（补丁仍在临时路径时使用）
+func (m *PatchManifest) AddPatchAs(patchPath, name, direction string) error {
+	d, err := digestPath(patchPath)
+	if err != nil {
+		return err
+	}
+	header, err := readPatchHeader(patchPath)
+	if err != nil {
+		return err
+	}
+	m.Patches = append(m.Patches, PatchEntry{
+		File:      name,
+		Direction: direction,
+		Format:    header.Format,
+		Size:      d.Size,
+		SHA256:    d.SHA256,
+	})
+	return nil
+}
+
+// 补丁的输入数据
+func (m *PatchManifest) Source(e *PatchEntry) PathDigest {
+	if e.Direction == DirectionReverse {
+		return m.New
+	}
+	return m.Old
+}
+
+// 补丁应用后应得到的数据
+func (m *PatchManifest) Target(e *PatchEntry) PathDigest {
+	if e.Direction == DirectionReverse {
+		return m.Old
+	}
+	return m.New
+}
+
+func (m *PatchManifest) Entry(patchFile string) *PatchEntry {
+	for i := range m.Patches {
+		if strings.EqualFold(m.Patches[i].File, patchFile) {
+			return &m.Patches[i]
+		}
+	}
+	return nil
+}
+
+func (m *PatchManifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func loadManifest(path string) (*PatchManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &PatchManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("清单格式错误 %s - %v", filepath.Base(path), err)
+	}
+	return m, nil
+}
+
+// 查找记录了该补丁的清单: 先找同名清单，再扫描同目录的其他清单（例如回退补丁共用正向补丁的清单）
+func findManifest(patchPath string) (*PatchManifest, *PatchEntry, error) {
+	name := filepath.Base(patchPath)
+	candidates := []string{manifestPathFor(patchPath)}
+	others, _ := filepath.Glob(filepath.Join(filepath.Dir(patchPath), "*"+manifestSuffix))
+	candidates = append(candidates, others...)
+	for _, path := range candidates {
+		m, err := loadManifest(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		if e := m.Entry(name); e != nil {
+			return m, e, nil
+		}
+	}
+	return nil, nil, os.ErrNotExist
+}
+
+// 计算文件或文件夹的摘要
+func digestPath(path string) (PathDigest, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return PathDigest{}, err
+	}
+	d := PathDigest{Name: filepath.Base(path)}
+	if !info.IsDir() {
+		sum, err := sha256File(path)
+		if err != nil {
+			return PathDigest{}, err
+		}
+		d.Kind = "file"
+		d.Size = info.Size()
+		d.SHA256 = sum
+		return d, nil
+	}
+
+	var rels []string
+	err = filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == path {
+			return nil
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if entry.IsDir() {
+			rel += "/"
+		}
+		rels = append(rels, rel)
+		return nil
+	})
+	if err != nil {
+		return PathDigest{}, err
+	}
+	sort.Strings(rels)
+	h := sha256.New()
+	for _, rel := range rels {
+		if strings.HasSuffix(rel, "/") {
+			fmt.Fprintf(h, "%s\n", rel)
+			continue
+		}
+		p := filepath.Join(path, filepath.FromSlash(rel))
+		sum, err := sha256File(p)
+		if err != nil {
+			return PathDigest{}, err
+		}
+		if fi, err := os.Stat(p); err == nil {
+			d.Size += fi.Size()
+		}
+		fmt.Fprintf(h, "%s\x00%s\n", rel, sum)
+	}
+	d.Kind = "dir"
+	d.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return d, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.CopyBuffer(h, f, make([]byte, 1024*1024)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// 补丁命名模板
+// {old}/{new}: 旧/新文件(夹)名，不含扩展名；{dir}: 方向标记；{ext}: 补丁扩展名
+const defaultNameTemplate = "{old}_patch{dir}{ext}"
+
+const defaultPatchExt = ".diff"
+
+// 方向标记，正向补丁为空以保持原有命名
+var directionMarkers = map[string]string{
+	DirectionForward: "",
+	DirectionReverse: "_reverse",
+}
+
+func baseNameNoExt(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func renderPatchName(tpl, oldPath, newPath, direction, ext string) string {
+	if strings.TrimSpace(tpl) == "" {
+		tpl = defaultNameTemplate
+	}
+	name := strings.NewReplacer(
+		"{old}", baseNameNoExt(oldPath),
+		"{new}", baseNameNoExt(newPath),
+		"{dir}", directionMarkers[direction],
+		"{ext}", ext,
+	).Replace(tpl)
+	// 模板中没有 {ext} 时补上扩展名
+	if !strings.Contains(tpl, "{ext}") && filepath.Ext(name) == "" {
+		name += ext
+	}
+	return name
+}
+
+// 回退补丁路径: 正向补丁使用模板生成的名字时按模板生成，否则在扩展名前加方向标记
+func reversePatchPath(patchPath, tpl, oldPath, newPath string) string {
+	dir := filepath.Dir(patchPath)
+	ext := filepath.Ext(patchPath)
+	if filepath.Base(patchPath) == renderPatchName(tpl, oldPath, newPath, DirectionForward, ext) {
+		return filepath.Join(dir, renderPatchName(tpl, oldPath, newPath, DirectionReverse, ext))
+	}
+	return strings.TrimSuffix(patchPath, ext) + directionMarkers[DirectionReverse] + ext
+}
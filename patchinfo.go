@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 从补丁文件头识别出的格式信息
+type PatchHeader struct {
+	Format   string   // HDIFF13 / HDIFFSF20 / HDIFF19 / BSDIFF40 / VCDIFF ...
+	Compress string   // 压缩类型，未压缩为空
+	Kind     FileType // 补丁对应的是单个文件还是文件夹
+	Bundle   bool     // 是否来自补丁包
+}
+
+func (h PatchHeader) String() string {
+	kind := "📄 单文件补丁"
+	if h.Kind == FileTypeDirectory {
+		kind = "📁 文件夹补丁"
+	}
+	if h.Bundle {
+		kind = "📦 补丁包 " + kind
+	}
+	if h.Compress == "" {
+		return fmt.Sprintf("%s %s", kind, h.Format)
+	}
+	return fmt.Sprintf("%s %s (%s)", kind, h.Format, h.Compress)
+}
+
+// HDiffPatch 系列文件头: "HDIFF13&zstd\0"
+var hdiffMagics = []struct {
+	magic string
+	kind  FileType
+}{
+	{"HDIFF13&", FileTypeFile},
+	{"HDIFFSF20&", FileTypeFile},
+	{"HDIFF19&", FileTypeDirectory},
+}
+
+func readPatchHeader(path string) (PatchHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return PatchHeader{}, err
+	}
+	defer f.Close()
+	buf := make([]byte, 64)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return PatchHeader{}, err
+	}
+	if bytes.HasPrefix(buf, zipMagic) {
+		return readBundleHeader(path)
+	}
+	return parsePatchHeader(buf[:n])
+}
+
+func parsePatchHeader(buf []byte) (PatchHeader, error) {
+	for _, m := range hdiffMagics {
+		if !bytes.HasPrefix(buf, []byte(m.magic)) {
+			continue
+		}
+		rest := buf[len(m.magic):]
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return PatchHeader{}, fmt.Errorf("补丁文件头不完整")
+		}
+		return PatchHeader{Format: m.magic[:len(m.magic)-1], Compress: string(rest[:end]), Kind: m.kind}, nil
+	}
+	switch {
+	case bytes.HasPrefix(buf, []byte("BSDIFF40")):
+		return PatchHeader{Format: "BSDIFF40", Compress: "bzip2", Kind: FileTypeFile}, nil
+	case bytes.HasPrefix(buf, []byte("ENDSLEY/BSDIFF43")):
+		return PatchHeader{Format: "BSDIFF43", Compress: "bzip2", Kind: FileTypeFile}, nil
+	case bytes.HasPrefix(buf, []byte{0xD6, 0xC3, 0xC4}):
+		return PatchHeader{Format: "VCDIFF", Kind: FileTypeFile}, nil
+	}
+	return PatchHeader{}, fmt.Errorf("无法识别的补丁格式")
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lxn/walk"
+)
+
+// 一个版本，以内容的 SHA256 区分
+type versionNode struct {
+	SHA256 string
+	Name   string
+	Kind   string
+	Size   int64
+}
+
+func (n *versionNode) Label() string {
+	return fmt.Sprintf("%s (%s)", n.Name, shortHash(n.SHA256))
+}
+
+// 一个补丁，从 From 版本更新到 To 版本
+type patchEdge struct {
+	From, To string
+	Path     string
+	Size     int64
+}
+
+// 补丁仓库的版本图，边的权重为补丁大小
+type versionGraph struct {
+	Nodes map[string]*versionNode
+	Edges []patchEdge
+	out   map[string][]int
+}
+
+func shortHash(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// 扫描目录（含子目录）中的所有清单，清单中记录且实际存在的补丁作为图的边
+func scanPatchRepo(dir string, log *LogSink) (*versionGraph, error) {
+	g := &versionGraph{Nodes: map[string]*versionNode{}, out: map[string][]int{}}
+	addNode := func(d PathDigest) {
+		if _, ok := g.Nodes[d.SHA256]; !ok {
+			g.Nodes[d.SHA256] = &versionNode{SHA256: d.SHA256, Name: d.Name, Kind: d.Kind, Size: d.Size}
+		}
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(d.Name()), manifestSuffix) {
+			return nil
+		}
+		m, err := loadManifest(path)
+		if err != nil {
+			log.Warnf("跳过清单 %s - %v", path, err)
+			return nil
+		}
+		for i := range m.Patches {
+			e := &m.Patches[i]
+			patchPath := filepath.Join(filepath.Dir(path), e.File)
+			info, err := os.Stat(patchPath)
+			if err != nil {
+				// 只发布了加密的补丁
+				patchPath += encExt
+				info, err = os.Stat(patchPath)
+			}
+			size := int64(0)
+			if err == nil {
+				size = info.Size()
+			} else {
+				// 分卷发布的补丁，使用第一卷作为补丁路径
+				for _, base := range []string{strings.TrimSuffix(patchPath, encExt), patchPath} {
+					if idx, ierr := loadVolumeIndex(base); ierr == nil {
+						patchPath, size, err = volumeName(base, 1), idx.Size, nil
+						break
+					}
+				}
+			}
+			if err != nil {
+				log.Warnf("清单 %s 中的补丁 %s 不存在", filepath.Base(path), e.File)
+				continue
+			}
+			from, to := m.Source(e), m.Target(e)
+			addNode(from)
+			addNode(to)
+			g.out[from.SHA256] = append(g.out[from.SHA256], len(g.Edges))
+			g.Edges = append(g.Edges, patchEdge{From: from.SHA256, To: to.SHA256, Path: patchPath, Size: size})
+		}
+		return nil
+	})
+	return g, err
+}
+
+// 按名称排序的版本列表
+func (g *versionGraph) SortedNodes() []*versionNode {
+	nodes := make([]*versionNode, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, k int) bool {
+		if nodes[i].Name != nodes[k].Name {
+			return nodes[i].Name < nodes[k].Name
+		}
+		return nodes[i].SHA256 < nodes[k].SHA256
+	})
+	return nodes
+}
+
+type pathItem struct {
+	node string
+	dist int64
+}
+
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, k int) bool  { return q[i].dist < q[k].dist }
+func (q pathQueue) Swap(i, k int)       { q[i], q[k] = q[k], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// 补丁总大小最小的更新路径 (Dijkstra)
+func (g *versionGraph) ShortestPath(from, to string) ([]patchEdge, int64, error) {
+	if from == to {
+		return nil, 0, fmt.Errorf("已是目标版本")
+	}
+	dist := map[string]int64{from: 0}
+	prev := map[string]int{}
+	q := &pathQueue{{node: from}}
+	for q.Len() > 0 {
+		cur := heap.Pop(q).(pathItem)
+		if cur.dist > dist[cur.node] {
+			continue
+		}
+		if cur.node == to {
+			break
+		}
+		for _, idx := range g.out[cur.node] {
+			e := g.Edges[idx]
+			d := cur.dist + e.Size
+			if old, ok := dist[e.To]; !ok || d < old {
+				dist[e.To] = d
+				prev[e.To] = idx
+				heap.Push(q, pathItem{node: e.To, dist: d})
+			}
+		}
+	}
+	if _, ok := dist[to]; !ok {
+		return nil, 0, fmt.Errorf("补丁仓库中没有可以到达目标版本的补丁")
+	}
+	var path []patchEdge
+	for n := to; n != from; {
+		e := g.Edges[prev[n]]
+		path = append([]patchEdge{e}, path...)
+		n = e.From
+	}
+	return path, dist[to], nil
+}
+
+// DOT 字符串只认 \" 和 \\ 转义，换行写成 \n；不能用 %q，Graphviz 不认识 \x/\u 转义
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r\n", `\n`, "\n", `\n`)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// 导出 Graphviz DOT，标出当前版本和选中的更新路径
+func (g *versionGraph) WriteDOT(w io.Writer, current string, highlight []patchEdge) error {
+	onPath := map[string]bool{}
+	for _, e := range highlight {
+		onPath[e.Path] = true
+	}
+	var sb strings.Builder
+	sb.WriteString("digraph patches {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, n := range g.SortedNodes() {
+		attrs := "label=" + dotQuote(fmt.Sprintf("%s\n%s\n%s", n.Name, shortHash(n.SHA256), formatSize(n.Size)))
+		if n.SHA256 == current {
+			attrs += ", style=filled, fillcolor=lightblue"
+		}
+		fmt.Fprintf(&sb, "\t%s [%s];\n", dotQuote(n.SHA256), attrs)
+	}
+	for _, e := range g.Edges {
+		attrs := "label=" + dotQuote(fmt.Sprintf("%s\n%s", filepath.Base(e.Path), formatSize(e.Size)))
+		if onPath[e.Path] {
+			attrs += ", color=red, penwidth=2"
+		}
+		fmt.Fprintf(&sb, "\t%s -> %s [%s];\n", dotQuote(e.From), dotQuote(e.To), attrs)
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// 扫描补丁仓库并刷新目标版本列表
+func (mw *AppMainWindow) scanRepo() {
+	repoPath := mw.PlanTab.RepoPathEdit.Text()
+	if getPathType(repoPath) != FileTypeDirectory {
+		mw.PlanTab.Log.Error("请选择补丁仓库文件夹")
+		return
+	}
+	mw.runJob(tabPlan, "扫描补丁仓库", func(j *job) error {
+		g, err := scanPatchRepo(repoPath, j.Log)
+		if err != nil {
+			return err
+		}
+		j.Log.Infof("共 %d 个版本，%d 个补丁", len(g.Nodes), len(g.Edges))
+		nodes := g.SortedNodes()
+		labels := make([]string, len(nodes))
+		for i, n := range nodes {
+			labels[i] = n.Label()
+		}
+		mw.Synchronize(func() {
+			mw.PlanTab.Graph = g
+			mw.PlanTab.Targets = nodes
+			mw.PlanTab.Plan = nil
+			mw.PlanTab.TargetCombo.SetModel(labels)
+			if len(labels) > 0 {
+				mw.PlanTab.TargetCombo.SetCurrentIndex(len(labels) - 1)
+			}
+		})
+		return nil
+	})
+}
+
+// 计算已安装版本的摘要，规划到目标版本的最小补丁路径
+func (mw *AppMainWindow) planUpdate() {
+	g := mw.PlanTab.Graph
+	installedPath := mw.PlanTab.InstalledPathEdit.Text()
+	idx := mw.PlanTab.TargetCombo.CurrentIndex()
+	if g == nil {
+		mw.PlanTab.Log.Error("请先扫描补丁仓库")
+		return
+	}
+	if installedPath == "" {
+		mw.PlanTab.Log.Error("请选择已安装的文件/文件夹")
+		return
+	}
+	if idx < 0 || idx >= len(mw.PlanTab.Targets) {
+		mw.PlanTab.Log.Error("请选择目标版本")
+		return
+	}
+	target := mw.PlanTab.Targets[idx]
+
+	mw.runJob(tabPlan, "规划更新路径", func(j *job) error {
+		j.Log.Info("计算已安装版本摘要...")
+		installed, err := digestPath(installedPath)
+		if err != nil {
+			return err
+		}
+		node, ok := g.Nodes[installed.SHA256]
+		if !ok {
+			return fmt.Errorf("补丁仓库中没有与已安装数据一致的版本 (SHA256: %s)", installed.SHA256)
+		}
+		j.Log.Info("已安装版本: " + node.Label())
+		path, total, err := g.ShortestPath(node.SHA256, target.SHA256)
+		if err != nil {
+			return err
+		}
+		for i, e := range path {
+			j.Log.Infof("%d. %s -> %s  %s  (%s)", i+1, g.Nodes[e.From].Name, g.Nodes[e.To].Name, filepath.Base(e.Path), formatSize(e.Size))
+		}
+		j.Log.Infof("共 %d 步，补丁总大小 %s", len(path), formatSize(total))
+		mw.Synchronize(func() {
+			mw.PlanTab.Current = node.SHA256
+			mw.PlanTab.Plan = path
+		})
+		return nil
+	})
+}
+
+// 把规划好的补丁链交给应用补丁页
+func (mw *AppMainWindow) usePlan() {
+	if len(mw.PlanTab.Plan) == 0 {
+		mw.PlanTab.Log.Error("请先计算更新路径")
+		return
+	}
+	patches := make([]string, len(mw.PlanTab.Plan))
+	for i, e := range mw.PlanTab.Plan {
+		patches[i] = e.Path
+	}
+	mw.ApplyTab.OldPathEdit.SetText(mw.PlanTab.InstalledPathEdit.Text())
+	mw.ApplyTab.PatchPathEdit.SetText(joinPatchList(patches))
+	mw.TabWidget.SetCurrentIndex(tabApply)
+}
+
+func (mw *AppMainWindow) exportDOT() {
+	g := mw.PlanTab.Graph
+	if g == nil {
+		mw.PlanTab.Log.Error("请先扫描补丁仓库")
+		return
+	}
+	dlg := new(walk.FileDialog)
+	dlg.Title = "导出版本图"
+	dlg.Filter = "Graphviz DOT (*.dot)|*.dot|所有文件 (*.*)|*.*"
+	dlg.FilePath = "patches.dot"
+	if ok, _ := dlg.ShowSave(mw.MainWindow); !ok || dlg.FilePath == "" {
+		return
+	}
+	f, err := os.Create(dlg.FilePath)
+	if err != nil {
+		mw.PlanTab.Log.Errorf("导出失败 - %v", err)
+		return
+	}
+	defer f.Close()
+	if err := g.WriteDOT(f, mw.PlanTab.Current, mw.PlanTab.Plan); err != nil {
+		mw.PlanTab.Log.Errorf("导出失败 - %v", err)
+		return
+	}
+	mw.PlanTab.Log.Info("版本图已导出: " + dlg.FilePath)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"hdiff-gui/hpatch"
+)
+
+// 剩余空间低于该值时无论估算结果如何都不执行
+const minFreeSpace = 64 << 20
+
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// 可用和总物理内存
+func memoryStatus() (avail, total uint64, err error) {
+	ms := memoryStatusEx{}
+	ms.Length = uint32(unsafe.Sizeof(ms))
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+	if r, _, e := proc.Call(uintptr(unsafe.Pointer(&ms))); r == 0 {
+		return 0, 0, e
+	}
+	return ms.AvailPhys, ms.TotalPhys, nil
+}
+
+// 文件大小，或文件夹内所有文件的总大小
+func pathSize(path string) (int64, error) {
+	var n int64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			n += info.Size()
+		}
+		return nil
+	})
+	return n, err
+}
+
+// 生成补丁大约需要的内存 (按 HDiffPatch 文档中的估算)
+// -m 需要旧数据的后缀数组，约 旧*5+新（超过 2GB 时为 旧*9+新）；-s 约 旧/4 + 64MB；内置 bsdiff 约 旧*17+新*3
+func diffMemory(backend, matchMode int, oldSize, newSize int64) int64 {
+	switch {
+	case backend == backendGo:
+		return oldSize*17 + newSize*3
+	case matchMode == 1:
+		return oldSize/4 + 64<<20
+	case oldSize >= 2<<30:
+		return oldSize*9 + newSize
+	}
+	return oldSize*5 + newSize
+}
+
+// 同一个卷上所需的空间
+type diskNeeds map[string]int64
+
+func (d diskNeeds) add(path string, n int64) {
+	if abs, err := filepath.Abs(path); err == nil && n > 0 {
+		d[strings.ToUpper(filepath.VolumeName(abs))] += n
+	}
+}
+
+// 检查每个卷的剩余空间；strict 时空间不足直接报错，否则只警告（所需空间只是上限估算）
+func (j *job) checkDiskNeeds(needs diskNeeds, strict bool) error {
+	vols := make([]string, 0, len(needs))
+	for v := range needs {
+		vols = append(vols, v)
+	}
+	sort.Strings(vols)
+	for _, vol := range vols {
+		need := needs[vol]
+		free, _, err := diskFree(vol + `\`)
+		if err != nil {
+			j.Log.Warnf("无法获取 %s 剩余空间 - %v", vol, err)
+			continue
+		}
+		msg := fmt.Sprintf("%s 剩余 %s，预计需要 %s", vol, formatSize(int64(free)), formatSize(need))
+		switch {
+		case free < minFreeSpace || (strict && int64(free) < need):
+			return fmt.Errorf("磁盘空间不足: %s", msg)
+		case int64(free) < need:
+			j.Log.Warn("磁盘空间可能不足: " + msg)
+		default:
+			j.Log.Info("磁盘空间: " + msg)
+		}
+	}
+	return nil
+}
+
+// 超过物理内存总量时报错，超过可用内存时只警告
+func (j *job) checkMemory(need int64, hint string) error {
+	avail, total, err := memoryStatus()
+	if err != nil {
+		j.Log.Warnf("无法获取内存信息 - %v", err)
+		return nil
+	}
+	msg := fmt.Sprintf("预计需要内存约 %s，可用 %s / 共 %s", formatSize(need), formatSize(int64(avail)), formatSize(int64(total)))
+	switch {
+	case need > int64(total):
+		return fmt.Errorf("内存不足: %s%s", msg, hint)
+	case need > int64(avail):
+		j.Log.Warn("内存可能不足: " + msg + hint)
+	default:
+		j.Log.Info("内存: " + msg)
+	}
+	return nil
+}
+
+// 生成补丁前的检查项
+type diffPreflight struct {
+	OldPath, NewPath, PatchPath string
+	MatchMode                   int
+	Reverse, Encrypt            bool
+	Bundle, SFX                 bool
+}
+
+// 检查内存和输出所需空间；内存明显不够时建议使用流式匹配 (-s)
+func (j *job) preflightDiff(p diffPreflight) error {
+	oldSize, err := pathSize(p.OldPath)
+	if err != nil {
+		return err
+	}
+	newSize, err := pathSize(p.NewPath)
+	if err != nil {
+		return err
+	}
+
+	need := diffMemory(j.Backend, p.MatchMode, oldSize, newSize)
+	if p.Reverse {
+		need = max(need, diffMemory(j.Backend, p.MatchMode, newSize, oldSize))
+	}
+	hint := ""
+	switch {
+	case j.Backend == backendGo:
+		hint = "，建议改用 hdiffz 的流式匹配 (-s)"
+	case p.MatchMode == 0:
+		hint = "，建议改用流式匹配 (-s)"
+	}
+	if err := j.checkMemory(need, hint); err != nil {
+		return err
+	}
+
+	// 补丁一般远小于新数据，这里按新数据大小估算上限
+	patchSize := newSize
+	if p.Reverse {
+		patchSize += oldSize
+	}
+	out := patchSize
+	if p.Encrypt {
+		out += patchSize
+	}
+	if p.Bundle {
+		out += patchSize
+	}
+	needs := diskNeeds{}
+	if p.SFX {
+		out += newSize
+		// 自解压程序在临时目录中验证
+		needs.add(os.TempDir(), oldSize+newSize)
+	}
+	needs.add(p.PatchPath, out)
+	return j.checkDiskNeeds(needs, false)
+}
+
+// 应用后新数据的大小: 先看补丁头，再看清单；都没有时按旧数据大小估算
+func patchResultSize(patch string, oldSize int64) (int64, bool) {
+	if f, err := os.Open(patch); err == nil {
+		defer f.Close()
+		if info, err := f.Stat(); err == nil {
+			if h, err := hpatch.ReadHeader(f, info.Size()); err == nil {
+				return int64(h.NewSize), true
+			}
+		}
+	}
+	if m, e, err := findManifest(patch); err == nil {
+		return m.Target(e).Size, true
+	}
+	return oldSize, false
+}
+
+// 应用补丁前检查输出、中间结果、解包/拼接/解密的补丁副本和就地更新备份所需的空间
+func (j *job) preflightApply(oldPath string, patches []string, outPath string, inPlace bool) error {
+	oldSize, err := pathSize(oldPath)
+	if err != nil {
+		return err
+	}
+	newSize, exact := oldSize, true
+	var mem int64
+	for _, p := range patches {
+		size, ok := patchResultSize(p, newSize)
+		// 内置应用器处理 BSDIFF40 时旧数据、补丁和新数据都在内存中；HDiffPatch 格式按流读取
+		if j.Backend == backendGo {
+			if h, err := readPatchHeader(p); err == nil && h.Format == "BSDIFF40" {
+				if patchSize, err := pathSize(p); err == nil {
+					mem = max(mem, newSize+patchSize+size)
+				}
+			}
+		}
+		newSize, exact = size, exact && ok
+	}
+	if mem > 0 {
+		if err := j.checkMemory(mem, "，建议改用 hdiffz"); err != nil {
+			return err
+		}
+	}
+	out := newSize
+	if len(patches) > 1 {
+		// 最多同时保留上一步和当前步的中间结果
+		out += max(oldSize, newSize)
+	}
+	for _, p := range patches {
+		// 分卷、补丁包和加密补丁需要先在工作目录中准备一份
+		if base := volumeBase(p); base != "" {
+			if idx, err := loadVolumeIndex(base); err == nil {
+				out += idx.Size
+			}
+		} else if isBundle(p) || isEncrypted(p) {
+			if size, err := pathSize(p); err == nil {
+				out += size
+			}
+		}
+	}
+	needs := diskNeeds{}
+	needs.add(outPath, out)
+	if inPlace {
+		if dir, err := transactionsDir(); err == nil {
+			needs.add(dir, oldSize)
+		}
+	}
+	if !exact {
+		j.Log.Info("未能从补丁头或清单得到新数据大小，按旧数据大小估算")
+	}
+	return j.checkDiskNeeds(needs, exact)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/lxn/walk"
+)
+
+// 界面已有对应控件的选项，不能再通过额外参数指定
+var (
+	managedDiffOptions  = []string{"-m", "-s", "-SD", "-BSD", "-VCD", "-f", "-d", "-t", "--patch"}
+	managedApplyOptions = []string{"-f", "--patch"}
+)
+
+// 解析额外参数: 按 Windows 规则拆分，只允许选项，且不能与界面中的选项重复
+// diff 为真时是生成补丁的参数，压缩参数也由界面管理
+func parseExtraArgs(text string, diff bool) ([]string, error) {
+	managed := managedApplyOptions
+	if diff {
+		managed = managedDiffOptions
+	}
+	tokens := splitCommandLine(text)
+	for _, t := range tokens {
+		if len(t) < 2 || t[0] != '-' {
+			return nil, fmt.Errorf("额外参数只能是以 - 开头的选项: %s", t)
+		}
+		if diff && compressorOf(t) != "" {
+			return nil, fmt.Errorf("额外参数 %s 与界面中的压缩选项重复，请使用界面选项", t)
+		}
+		o := optionOf(t)
+		for _, m := range managed {
+			if o == m {
+				return nil, fmt.Errorf("额外参数 %s 与界面中的选项重复，请使用界面选项", t)
+			}
+		}
+	}
+	return newArgs(tokens...).Build()
+}
+
+func (mw *AppMainWindow) diffExtraArgs() ([]string, error) {
+	if mw.PatchTab.ExtraArgsEdit == nil {
+		return nil, nil
+	}
+	extra, err := parseExtraArgs(mw.PatchTab.ExtraArgsEdit.Text(), true)
+	if err == nil && len(extra) > 0 && mw.diffBackend() == backendGo {
+		err = fmt.Errorf("内置差分引擎不支持额外参数")
+	}
+	return extra, err
+}
+
+func (mw *AppMainWindow) applyExtraArgs() ([]string, error) {
+	if mw.ApplyTab.ExtraArgsEdit == nil {
+		return nil, nil
+	}
+	extra, err := parseExtraArgs(mw.ApplyTab.ExtraArgsEdit.Text(), false)
+	if err == nil && len(extra) > 0 && mw.applyBackend() == backendGo {
+		err = fmt.Errorf("内置应用器不支持额外参数")
+	}
+	return extra, err
+}
+
+// 当前设置对应的命令行，无法生成时返回原因
+func (mw *AppMainWindow) commandPreview(tab int) string {
+	var args []string
+	var err error
+	if tab == tabPatch {
+		if mw.diffBackend() == backendGo {
+			return "(内置 bsdiff 差分引擎，不运行外部命令)"
+		}
+		args, err = mw.patchArgs()
+	} else {
+		if mw.applyBackend() == backendGo {
+			return "(内置应用器，不运行外部命令)"
+		}
+		args, err = mw.applyArgs()
+	}
+	if err != nil {
+		return "⚠ " + err.Error()
+	}
+	toolPath, err := findTool("hdiffz.exe")
+	if err != nil {
+		toolPath = "hdiffz.exe"
+	}
+	return commandLine(toolPath, args)
+}
+
+func (mw *AppMainWindow) updatePreview(tab int) {
+	edit := mw.PatchTab.PreviewEdit
+	if tab == tabApply {
+		edit = mw.ApplyTab.PreviewEdit
+	}
+	if edit != nil {
+		edit.SetText(mw.commandPreview(tab))
+	}
+}
+
+// 任一选项变化时刷新命令预览
+func (mw *AppMainWindow) watchPreview() {
+	watch := func(tab int, widgets ...walk.Widget) {
+		update := func() { mw.updatePreview(tab) }
+		for _, w := range widgets {
+			switch w := w.(type) {
+			case *walk.LineEdit:
+				w.TextChanged().Attach(update)
+			case *walk.CheckBox:
+				w.CheckedChanged().Attach(update)
+			case *walk.ComboBox:
+				w.CurrentIndexChanged().Attach(update)
+			}
+		}
+		update()
+	}
+	p := mw.PatchTab
+	watch(tabPatch, p.OldPathEdit, p.NewPathEdit, p.OutPutEdit, p.ExtraArgsEdit,
+		p.OverwriteCheck, p.CompressCheck, p.SkipVerifyCheck,
+		p.FormatCombo, p.MatchModeCombo, p.BackendCombo)
+	a := mw.ApplyTab
+	watch(tabApply, a.OldPathEdit, a.PatchPathEdit, a.OutPutEdit, a.ExtraArgsEdit,
+		a.OverwriteCheck, a.BackendCombo)
+}
+
+// 按编辑过的预览命令设置界面，界面没有对应控件的选项放入额外参数
+func (mw *AppMainWindow) applyPreview(tab int) {
+	edit := mw.PatchTab.PreviewEdit
+	if tab == tabApply {
+		edit = mw.ApplyTab.PreviewEdit
+	}
+	c, err := parseCommandLine(edit.Text())
+	if err != nil {
+		mw.tabLog(tab).Errorf("解析命令行失败 - %v", err)
+		return
+	}
+	mw.applyImported(c)
+}
+
+// 检查 hdiffz 是否支持 args 并记录命令行，不运行
+func (j *job) checkTool(args []string) error {
+	toolPath, err := findTool("hdiffz.exe")
+	if err != nil {
+		return err
+	}
+	if caps, err := toolCapabilities(toolPath); err != nil {
+		j.Log.Warnf("检测 hdiffz 版本失败 - %v", err)
+	} else if err := caps.Check(args); err != nil {
+		return err
+	}
+	j.Log.Info("命令行 (不执行): " + commandLine(toolPath, args))
+	return nil
+}
+
+// 只做应用补丁前的检查: 补丁类型、空间、输出路径和 hdiffz 选项，不写入任何文件
+func (mw *AppMainWindow) dryRunApply() {
+	oldPath, patches, err := mw.applyInputs()
+	if err != nil {
+		mw.ApplyTab.Log.Error(err.Error())
+		return
+	}
+	inPlace := mw.ApplyTab.InPlaceCheck.Checked()
+	newPath := cleanPath(mw.ApplyTab.OutPutEdit.Text())
+	if inPlace {
+		newPath = oldPath
+	} else if newPath == "" {
+		mw.ApplyTab.Log.Error("请指定新文件输出路径")
+		return
+	}
+	overwrite := mw.ApplyTab.OverwriteCheck.Checked()
+
+	mw.runApplyJob("检查 (不执行)", func(j *job) error {
+		for _, p := range patches {
+			// 加密或分卷补丁要在应用时才能读取文件头
+			header, err := readPatchHeader(p)
+			if err != nil {
+				j.Log.Warnf("读取补丁类型失败 %s - %v", filepath.Base(p), err)
+				continue
+			}
+			j.Log.Infof("补丁类型: %s (%s)", header.String(), filepath.Base(p))
+		}
+		if !inPlace {
+			if err := checkOutputTarget(newPath, overwrite); err != nil {
+				return err
+			}
+		}
+		if err := j.preflightApply(oldPath, patches, newPath, inPlace); err != nil {
+			return err
+		}
+		// 补丁链、补丁包等由程序分步处理，只能显示单个补丁的命令
+		if j.Backend == backendTool && len(patches) == 1 && !isBundle(patches[0]) && volumeBase(patches[0]) == "" {
+			args, err := newArgs("--patch").Opt(j.ExtraArgs...).Path(oldPath, patches[0], newPath).Build()
+			if err != nil {
+				return err
+			}
+			if err := j.checkTool(args); err != nil {
+				return err
+			}
+		}
+		j.Log.Info("检查通过，未执行任何操作")
+		return nil
+	})
+}
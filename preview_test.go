@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExtraArgs(t *testing.T) {
+	tests := []struct {
+		text string
+		diff bool
+		want []string
+		err  string
+	}{
+		{"", true, []string{}, ""},
+		{"-p-8 -cache", true, []string{"-p-8", "-cache"}, ""},
+		{`  -p-4	"-n-1024"  `, false, []string{"-p-4", "-n-1024"}, ""},
+		{"-s-4m", false, []string{"-s-4m"}, ""},
+		{"-p-8 old.bin", true, nil, "只能是以 - 开头的选项"},
+		{"-", false, nil, "只能是以 - 开头的选项"},
+		{"-f", true, nil, "与界面中的选项重复"},
+		{"-f", false, nil, "与界面中的选项重复"},
+		{"-s-64", true, nil, "与界面中的选项重复"},
+		{"-SD-4k", true, nil, "与界面中的选项重复"},
+		{"--patch", false, nil, "与界面中的选项重复"},
+		{"-c-lzma-9", true, nil, "压缩选项重复"},
+		// 应用补丁时压缩参数不由界面管理
+		{"-c-lzma-9", false, []string{"-c-lzma-9"}, ""},
+	}
+	for _, tt := range tests {
+		got, err := parseExtraArgs(tt.text, tt.diff)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseExtraArgs(%q, %v) err = %v，应包含 %q", tt.text, tt.diff, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseExtraArgs(%q, %v) = %q, %v，应为 %q", tt.text, tt.diff, got, err, tt.want)
+		}
+	}
+}
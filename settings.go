@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const settingsFile = "settings.json"
+
+// 受信任的签名公钥
+type TrustedKey struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"` // base64
+}
+
+// 保存在程序数据目录中的设置
+type Settings struct {
+	SigningKey       string       `json:"signing_key,omitempty"` // 私钥文件路径
+	TrustedKeys      []TrustedKey `json:"trusted_keys,omitempty"`
+	RequireSignature bool         `json:"require_signature"`
+}
+
+var (
+	settingsMu sync.Mutex
+	settings   *Settings
+)
+
+func settingsPath() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, settingsFile), nil
+}
+
+// 当前设置的副本，首次调用时从文件读取，文件不存在时使用默认值
+func loadSettings() (Settings, error) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	if settings != nil {
+		return *settings, nil
+	}
+	s := &Settings{}
+	path, err := settingsPath()
+	if err != nil {
+		return *s, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return *s, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return *s, err
+		}
+	}
+	settings = s
+	return *s, nil
+}
+
+// 修改并保存设置；设置文件读取或解析失败时不保存，避免覆盖其中的信任列表
+func updateSettings(fn func(s *Settings)) error {
+	s, err := loadSettings()
+	if err != nil {
+		return fmt.Errorf("读取设置失败，未保存 - %v", err)
+	}
+	s.TrustedKeys = append([]TrustedKey{}, s.TrustedKeys...)
+	fn(&s)
+	path, err := settingsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(&s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	settingsMu.Lock()
+	settings = &s
+	settingsMu.Unlock()
+	return nil
+}
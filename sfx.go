@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 自解压程序使用的 hpatchz 存根
+type sfxStub struct {
+	Name     string
+	Default  string // 程序目录下的默认存根
+	Ext      string
+	Runnable bool // 能否在本机运行以验证
+}
+
+var sfxStubs = []sfxStub{
+	{Name: "Windows (hpatchz.exe)", Default: "hpatchz.exe", Ext: ".exe", Runnable: true},
+	{Name: "Linux (hpatchz)", Default: "hpatchz", Ext: ".run"},
+}
+
+func sfxStubNames() []string {
+	names := make([]string, len(sfxStubs))
+	for i, s := range sfxStubs {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func sfxStubAt(i int) sfxStub {
+	if i < 0 || i >= len(sfxStubs) {
+		return sfxStubs[0]
+	}
+	return sfxStubs[i]
+}
+
+// 存根路径：未指定时使用程序目录下的默认存根
+func (s sfxStub) Resolve(path string) (string, error) {
+	path = cleanPath(path)
+	if path == "" {
+		return findTool(s.Default)
+	}
+	if getPathType(path) != FileTypeFile {
+		return "", fmt.Errorf("自解压存根不存在 - %s", path)
+	}
+	return path, nil
+}
+
+// 自解压程序路径: 补丁名去掉扩展名加 _sfx
+func sfxPathFor(patchPath string, stub sfxStub) string {
+	return strings.TrimSuffix(patchPath, filepath.Ext(patchPath)) + "_sfx" + stub.Ext
+}
+
+// 自解压程序的启动脚本，双击后更新默认目标目录
+func sfxLauncherPath(sfxPath string, stub sfxStub) string {
+	base := strings.TrimSuffix(sfxPath, filepath.Ext(sfxPath))
+	if stub.Runnable {
+		return base + ".cmd"
+	}
+	return base + ".sh"
+}
+
+func sfxLauncher(sfxPath, targetDir string, stub sfxStub) string {
+	name := filepath.Base(sfxPath)
+	if stub.Runnable {
+		return fmt.Sprintf("@echo off\r\n\"%%~dp0%s\" -f \"%s\" -X \"%s\"\r\npause\r\n", name, targetDir, targetDir)
+	}
+	return fmt.Sprintf("#!/bin/sh\ncd \"$(dirname \"$0\")\" || exit 1\nchmod +x ./%s\nexec ./%s -f '%s' -X '%s'\n", name, name, targetDir, targetDir)
+}
+
+// 用 hpatchz 把补丁和存根打包成自解压程序
+// 运行方式: sfx 旧路径 -X 新路径；不带参数时更新所在目录
+func (j *job) buildSFX(patchPath, stubPath, outPath string) error {
+	hpatchz, args, err := j.sfxCommand(patchPath, stubPath, outPath)
+	if err != nil {
+		return err
+	}
+	return j.runExe(hpatchz, args)
+}
+
+// 生成自解压程序的 hpatchz 命令，并检查 hpatchz 是否支持
+func (j *job) sfxCommand(patchPath, stubPath, outPath string) (string, []string, error) {
+	hpatchz, err := findTool("hpatchz.exe")
+	if err != nil {
+		return "", nil, err
+	}
+	args, err := newArgs().OptPath("-X-exe#", stubPath).Path(patchPath).OptPath("-X#", outPath).Build()
+	if err != nil {
+		return "", nil, err
+	}
+	if caps, err := toolCapabilities(hpatchz); err != nil {
+		j.Log.Warnf("检测 hpatchz 版本失败 - %v", err)
+	} else if err := caps.Check(args); err != nil {
+		return "", nil, fmt.Errorf("%v (生成自解压程序需要 hpatchz 支持 -X)", err)
+	}
+	return hpatchz, args, nil
+}
+
+// 把旧数据复制到临时目录，运行自解压程序并与期望结果比对
+func (j *job) verifySFX(sfxPath, oldPath string, expected *PathDigest) error {
+	tmpDir, err := os.MkdirTemp("", "hdiffz-sfx-")
+	if err != nil {
+		return fmt.Errorf("创建临时目录失败 - %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			j.Log.Warnf("清理临时目录失败 %s - %v", tmpDir, err)
+		}
+	}()
+	oldCopy := filepath.Join(tmpDir, "old")
+	if err := copyPath(oldPath, oldCopy); err != nil {
+		return fmt.Errorf("复制旧数据失败 - %v", err)
+	}
+	outPath := filepath.Join(tmpDir, "new")
+	args, err := newArgs().Path(oldCopy).Opt("-X").Path(outPath).Build()
+	if err != nil {
+		return err
+	}
+	if err := j.runExe(sfxPath, args); err != nil {
+		return err
+	}
+	return checkDigest(j, outPath, expected, "新数据")
+}
+
+// 复制文件或整个文件夹
+func copyPath(src, dst string) error {
+	if getPathType(src) != FileTypeDirectory {
+		return copyFile(src, dst)
+	}
+	tree, err := listTree(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for rel, isDir := range tree {
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		if isDir {
+			err = os.MkdirAll(target, 0755)
+		} else {
+			err = copyFile(filepath.Join(src, filepath.FromSlash(rel)), target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把生成的补丁打包成自解压程序，能在本机运行的存根会先对旧数据副本试运行
+func (j *job) packageSFX(patchPath, oldPath, newPath string, stub sfxStub, stubPath, targetDir string, overwrite bool) error {
+	stubPath, err := stub.Resolve(stubPath)
+	if err != nil {
+		return err
+	}
+	sfxPath := sfxPathFor(patchPath, stub)
+	if err := checkOutputTarget(sfxPath, overwrite); err != nil {
+		return err
+	}
+	j.Log.Info("生成自解压程序...")
+	// 保留扩展名，Windows 存根才能直接运行
+	tmp := tempSibling(sfxPath, j.ID) + stub.Ext
+	defer os.Remove(tmp)
+	if err := j.buildSFX(patchPath, stubPath, tmp); err != nil {
+		return err
+	}
+	if stub.Runnable {
+		j.Log.Info("验证自解压程序...")
+		expected, err := digestPath(newPath)
+		if err != nil {
+			return fmt.Errorf("计算新数据摘要失败 - %v", err)
+		}
+		if err := j.verifySFX(tmp, oldPath, &expected); err != nil {
+			return fmt.Errorf("自解压程序验证失败 - %v", err)
+		}
+	} else {
+		j.Log.Warn("目标平台的自解压程序无法在本机运行，已跳过验证")
+	}
+	if err := commitOutput(tmp, sfxPath, overwrite); err != nil {
+		return err
+	}
+	j.Log.Info("自解压程序已保存: " + sfxPath)
+	if targetDir == "" {
+		return nil
+	}
+	launcher := sfxLauncherPath(sfxPath, stub)
+	if err := os.WriteFile(launcher, []byte(sfxLauncher(sfxPath, targetDir, stub)), 0755); err != nil {
+		return fmt.Errorf("保存启动脚本失败 - %v", err)
+	}
+	j.Log.Info("启动脚本已保存: " + launcher)
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lxn/walk"
+)
+
+// 签名文件与被签名文件同名，加 .sig 后缀
+const sigExt = ".sig"
+
+// 签名的是文件 SHA256 摘要加上固定前缀，避免与其他用途的签名混用
+const sigContext = "hdiffz-gui signature v1\n"
+
+type signatureFile struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"` // base64
+}
+
+var errUnsigned = errors.New("未签名")
+
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// 生成密钥对，私钥保存到 privPath，公钥保存到同名 .pub
+func generateKeyPair(privPath string) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return "", err
+	}
+	pubPath := strings.TrimSuffix(privPath, ".key") + ".pub"
+	der, err = x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return pubPath, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+}
+
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s 不是私钥文件", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s 不是 Ed25519 私钥", path)
+	}
+	return priv, nil
+}
+
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s 不是公钥文件", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s 不是 Ed25519 公钥", path)
+	}
+	return pub, nil
+}
+
+func signDigest(priv ed25519.PrivateKey, digest []byte) ([]byte, error) {
+	sig := ed25519.Sign(priv, append([]byte(sigContext), digest...))
+	return json.MarshalIndent(signatureFile{
+		Algorithm: "ed25519",
+		KeyID:     keyID(priv.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}, "", "  ")
+}
+
+// 为文件生成 .sig
+func signFile(priv ed25519.PrivateKey, path string) error {
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+	sig, err := signDigest(priv, digest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+sigExt, sig, 0644)
+}
+
+func fileDigest(path string) ([]byte, error) {
+	sum, err := sha256File(path)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(sum)
+}
+
+// 用受信任的公钥验证签名，返回签名者名称
+func verifySignature(sigData, digest []byte, trusted []TrustedKey) (string, error) {
+	var sf signatureFile
+	if err := json.Unmarshal(sigData, &sf); err != nil {
+		return "", fmt.Errorf("签名文件格式错误 - %v", err)
+	}
+	if sf.Algorithm != "ed25519" {
+		return "", fmt.Errorf("不支持的签名算法: %s", sf.Algorithm)
+	}
+	sig, err := base64.StdEncoding.DecodeString(sf.Signature)
+	if err != nil {
+		return "", fmt.Errorf("签名文件格式错误 - %v", err)
+	}
+	msg := append([]byte(sigContext), digest...)
+	for _, k := range trusted {
+		pub, err := base64.StdEncoding.DecodeString(k.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize || keyID(pub) != sf.KeyID {
+			continue
+		}
+		if !ed25519.Verify(pub, msg, sig) {
+			return "", fmt.Errorf("签名与内容不匹配 (密钥 %s)，文件可能已被篡改", k.Name)
+		}
+		return k.Name, nil
+	}
+	return "", fmt.Errorf("签名密钥 %s 不在信任列表中", sf.KeyID)
+}
+
+// 检查签名: 签名无效时总是报错；未签名时按设置决定报错还是警告
+func (j *job) checkSignature(what string, sigData, digest []byte) error {
+	// 读不到设置时无法确认是否必须验证签名，也没有信任列表，不能继续
+	s, err := loadSettings()
+	if err != nil {
+		return fmt.Errorf("读取设置失败，无法检查%s签名 - %v", what, err)
+	}
+	if sigData == nil {
+		if s.RequireSignature {
+			return fmt.Errorf("%s%v，已设置必须验证签名", what, errUnsigned)
+		}
+		j.Log.Warnf("%s%v，未验证来源", what, errUnsigned)
+		return nil
+	}
+	signer, err := verifySignature(sigData, digest, s.TrustedKeys)
+	if err != nil {
+		return fmt.Errorf("%s签名验证失败: %v", what, err)
+	}
+	j.Log.Infof("%s签名验证通过，签名者: %s", what, signer)
+	return nil
+}
+
+// 检查文件旁的 .sig
+func (j *job) checkFileSignature(what, path string) error {
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+	sig, err := os.ReadFile(path + sigExt)
+	if os.IsNotExist(err) {
+		sig, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	return j.checkSignature(what, sig, digest)
+}
+
+// 生成密钥对，并设为签名私钥、加入信任列表
+func (mw *AppMainWindow) generateSigningKey() {
+	log := mw.PatchTab.Log
+	dlg := new(walk.FileDialog)
+	dlg.Title = "保存签名私钥"
+	dlg.Filter = "私钥 (*.key)|*.key"
+	dlg.FilePath = "hdiffz-sign.key"
+	if ok, _ := dlg.ShowSave(mw.MainWindow); !ok || dlg.FilePath == "" {
+		return
+	}
+	privPath := dlg.FilePath
+	if !strings.HasSuffix(strings.ToLower(privPath), ".key") {
+		privPath += ".key"
+	}
+	pubPath, err := generateKeyPair(privPath)
+	if err != nil {
+		log.Errorf("生成密钥失败 - %v", err)
+		return
+	}
+	if err := mw.trustPublicKey(pubPath); err != nil {
+		log.Errorf("加入信任列表失败 - %v", err)
+	}
+	mw.PatchTab.SigningKeyEdit.SetText(privPath)
+	log.Info("私钥已保存: " + privPath + " (请妥善保管，不要随补丁分发)")
+	log.Info("公钥已保存: " + pubPath)
+}
+
+func (mw *AppMainWindow) trustPublicKey(pubPath string) error {
+	pub, err := loadPublicKey(pubPath)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(pub)
+	name := baseNameNoExt(pubPath) + " (" + keyID(pub) + ")"
+	err = updateSettings(func(s *Settings) {
+		for _, k := range s.TrustedKeys {
+			if k.PublicKey == encoded {
+				return
+			}
+		}
+		s.TrustedKeys = append(s.TrustedKeys, TrustedKey{Name: name, PublicKey: encoded})
+	})
+	if err == nil {
+		mw.updateTrustedLabel()
+	}
+	return err
+}
+
+// 选择公钥加入信任列表
+func (mw *AppMainWindow) selectTrustedKey() {
+	dlg := new(walk.FileDialog)
+	dlg.Title = "选择受信任的公钥"
+	dlg.Filter = "公钥 (*.pub)|*.pub|所有文件 (*.*)|*.*"
+	if ok, _ := dlg.ShowOpen(mw.MainWindow); !ok || dlg.FilePath == "" {
+		return
+	}
+	if err := mw.trustPublicKey(dlg.FilePath); err != nil {
+		mw.ApplyTab.Log.Errorf("加入信任列表失败 - %v", err)
+		return
+	}
+	mw.ApplyTab.Log.Info("已信任公钥: " + dlg.FilePath)
+}
+
+func (mw *AppMainWindow) updateTrustedLabel() {
+	s, err := loadSettings()
+	if err != nil {
+		mw.ApplyTab.TrustedLabel.SetText("读取设置失败")
+		return
+	}
+	mw.ApplyTab.TrustedLabel.SetText(fmt.Sprintf("已信任 %d 个公钥", len(s.TrustedKeys)))
+}
+
+func (mw *AppMainWindow) setRequireSignature(require bool) {
+	if err := updateSettings(func(s *Settings) { s.RequireSignature = require }); err != nil {
+		mw.ApplyTab.Log.Errorf("保存设置失败 - %v", err)
+	}
+}
+
+// 读取签名私钥，并记住路径
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	priv, err := readSigningKey(path)
+	if err != nil {
+		return nil, err
+	}
+	_ = updateSettings(func(s *Settings) { s.SigningKey = cleanPath(path) })
+	return priv, nil
+}
+
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	path = cleanPath(path)
+	if path == "" {
+		return nil, errors.New("请选择签名私钥，或先生成密钥")
+	}
+	return loadPrivateKey(path)
+}
+
+// 签名补丁包内的 SHA256SUMS
+func bundleSigner(priv ed25519.PrivateKey) func([]byte) ([]byte, error) {
+	if priv == nil {
+		return nil
+	}
+	return func(sums []byte) ([]byte, error) {
+		digest := sha256.Sum256(sums)
+		return signDigest(priv, digest[:])
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 探测结果缓存在程序数据目录中，工具文件的大小或修改时间变化后重新探测
+const toolsCacheFile = "tools.json"
+
+// 工具的版本和帮助信息中列出的参数
+type toolCaps struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	Version     string    `json:"version"`
+	Compressors []string  `json:"compressors"`
+	Options     []string  `json:"options"`
+}
+
+// 界面会用到、需要检查的参数
+var knownOptions = []string{"-m", "-s", "-SD", "-BSD", "-VCD", "-f", "-d", "-t", "-p", "-X", "--patch"}
+
+var (
+	toolsMu    sync.Mutex
+	toolsCache map[string]*toolCaps
+
+	versionRe    = regexp.MustCompile(`v(\d+\.\d+(?:\.\d+)?)`)
+	optionRe     = regexp.MustCompile(`(?m)^\s*(--?[A-Za-z]+)`)
+	compressorRe = regexp.MustCompile(`-c-([a-z][a-z0-9]*)`)
+)
+
+// 运行工具并返回输出，不写入任务日志
+func probeTool(path string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = filepath.Dir(path)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: 0x08000000,
+	}
+	// 显示帮助时返回码可能非 0，有输出即可
+	out, err := cmd.CombinedOutput()
+	if len(out) == 0 && err != nil {
+		return "", err
+	}
+	return string(decodeOutput(out, Cp)), nil
+}
+
+func parseToolHelp(help string) (version string, options, compressors []string) {
+	if m := versionRe.FindStringSubmatch(help); m != nil {
+		version = m[1]
+	}
+	seen := map[string]bool{}
+	for _, m := range optionRe.FindAllStringSubmatch(help, -1) {
+		seen[m[1]] = true
+	}
+	for _, o := range knownOptions {
+		if seen[o] {
+			options = append(options, o)
+		}
+	}
+	seen = map[string]bool{}
+	for _, m := range compressorRe.FindAllStringSubmatch(help, -1) {
+		if name := m[1]; name != "compress" && !seen[name] {
+			seen[name] = true
+			compressors = append(compressors, name)
+		}
+	}
+	sort.Strings(compressors)
+	return
+}
+
+func toolsCachePath() (string, error) {
+	dir, err := appDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, toolsCacheFile), nil
+}
+
+func loadToolsCache() map[string]*toolCaps {
+	cache := map[string]*toolCaps{}
+	if path, err := toolsCachePath(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			_ = json.Unmarshal(data, &cache)
+		}
+	}
+	return cache
+}
+
+func saveToolsCache(cache map[string]*toolCaps) error {
+	path, err := toolsCachePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// 工具的版本和支持的参数，每个工具文件只探测一次
+func toolCapabilities(path string) (*toolCaps, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	if toolsCache == nil {
+		toolsCache = loadToolsCache()
+	}
+	key := strings.ToLower(path)
+	if c := toolsCache[key]; c != nil && c.Size == info.Size() && c.ModTime.Equal(info.ModTime()) {
+		return c, nil
+	}
+	help, err := probeTool(path, "-h")
+	if err != nil {
+		return nil, fmt.Errorf("运行 %s -h 失败 - %v", filepath.Base(path), err)
+	}
+	c := &toolCaps{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+	c.Version, c.Options, c.Compressors = parseToolHelp(help)
+	if c.Version == "" {
+		if out, err := probeTool(path, "-v"); err == nil {
+			c.Version, _, _ = parseToolHelp(out)
+		}
+	}
+	toolsCache[key] = c
+	_ = saveToolsCache(toolsCache)
+	return c, nil
+}
+
+// 没有从帮助中识别出任何参数时（格式无法识别），不做限制
+func (c *toolCaps) known() bool {
+	return c != nil && len(c.Options) > 0
+}
+
+func (c *toolCaps) Has(opt string) bool {
+	if !c.known() {
+		return true
+	}
+	for _, o := range c.Options {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *toolCaps) HasCompressor(name string) bool {
+	if !c.known() || len(c.Compressors) == 0 {
+		return true
+	}
+	for _, n := range c.Compressors {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *toolCaps) String() string {
+	version := "v" + c.Version
+	if c.Version == "" {
+		version = "(未知版本)"
+	}
+	return fmt.Sprintf("%s %s", filepath.Base(c.Path), version)
+}
+
+// "-c-zstd-21-24" 中的压缩类型
+func compressorOf(arg string) string {
+	if !strings.HasPrefix(arg, "-c-") {
+		return ""
+	}
+	return strings.SplitN(arg[3:], "-", 2)[0]
+}
+
+// 参数对应的已知选项，例如 "-s-64" 对应 "-s"，"-X-exe#stub" 对应 "-X"
+func optionOf(arg string) string {
+	best := ""
+	for _, o := range knownOptions {
+		if (arg == o || strings.HasPrefix(arg, o+"-") || strings.HasPrefix(arg, o+"#")) && len(o) > len(best) {
+			best = o
+		}
+	}
+	return best
+}
+
+// 检查参数是否都被工具支持，不支持时给出明确的提示
+func (c *toolCaps) Check(args []string) error {
+	for _, a := range args {
+		if name := compressorOf(a); name != "" {
+			if !c.HasCompressor(name) {
+				return fmt.Errorf("%s 不支持压缩类型 %s (%s)，请更新工具或取消压缩", c, name, a)
+			}
+			continue
+		}
+		if o := optionOf(a); o != "" && !c.Has(o) {
+			return fmt.Errorf("%s 不支持 %s 参数，请更新工具", c, o)
+		}
+	}
+	return nil
+}
+
+// 启动后在后台探测 hdiffz，按支持情况调整界面
+func (mw *AppMainWindow) detectTools() {
+	path, err := findTool("hdiffz.exe")
+	if err != nil {
+		return
+	}
+	caps, err := toolCapabilities(path)
+	mw.Synchronize(func() {
+		if err != nil {
+			mw.PatchTab.Log.Warnf("检测 hdiffz 版本失败 - %v", err)
+			return
+		}
+		mw.Tools = caps
+		mw.PatchTab.Log.Infof("检测到 %s，支持的压缩类型: %s", caps, strings.Join(caps.Compressors, ", "))
+		mw.updateDiffBackend()
+		if !caps.Has("--patch") && mw.applyBackend() == backendTool {
+			mw.ApplyTab.BackendCombo.SetCurrentIndex(backendGo)
+			mw.ApplyTab.Log.Warnf("%s 不支持 --patch，已改用内置应用器", caps)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 将补丁（或补丁链）试应用到临时目录，再与清单或参考数据比对，结束后删除临时文件
+func (mw *AppMainWindow) verifyApply() {
+	oldPath, patches, err := mw.applyInputs()
+	if err != nil {
+		mw.ApplyTab.Log.Error(err.Error())
+		return
+	}
+	refPath := cleanPath(mw.ApplyTab.RefPathEdit.Text())
+
+	mw.runApplyJob("验证应用", func(j *job) error {
+		header, err := readPatchHeader(patches[0])
+		if err != nil {
+			return err
+		}
+		j.Log.Info("补丁类型: " + header.String())
+		if len(patches) == 1 {
+			mw.Synchronize(func() {
+				mw.ApplyTab.PatchPathType = header.Kind
+				mw.ApplyTab.PatchPathLabel.SetText(header.String())
+			})
+		}
+
+		tmpDir, err := os.MkdirTemp("", "hdiffz-verify-")
+		if err != nil {
+			return fmt.Errorf("创建临时目录失败 - %v", err)
+		}
+		defer func() {
+			if err := os.RemoveAll(tmpDir); err != nil {
+				j.Log.Warnf("清理临时目录失败 %s - %v", tmpDir, err)
+			}
+		}()
+		outPath := filepath.Join(tmpDir, "new")
+		verified, err := j.applyChain(oldPath, patches, outPath, filepath.Join(tmpDir, "steps"), refPath)
+		if err != nil {
+			return err
+		}
+		if !verified {
+			j.Log.Warn("未找到清单且未指定参考数据，仅确认补丁可以成功应用")
+		}
+		return nil
+	})
+}
+
+// 比对应用结果与期望摘要
+func checkDigest(j *job, path string, expected *PathDigest, source string) error {
+	got, err := digestPath(path)
+	if err != nil {
+		return fmt.Errorf("计算结果摘要失败 - %v", err)
+	}
+	if got.SHA256 != expected.SHA256 {
+		return fmt.Errorf("应用结果与%s不一致\r\n期望 SHA256: %s\r\n实际 SHA256: %s", source, expected.SHA256, got.SHA256)
+	}
+	j.Log.Infof("应用结果与%s一致，SHA256: %s", source, got.SHA256)
+	return nil
+}
+
+// 期望的应用结果: 优先使用补丁清单，其次使用参考数据；都没有时返回 nil
+// 有清单时同时检查旧数据和补丁本身是否与清单一致
+func expectedResult(j *job, oldPath, patchPath, refPath string) (*PathDigest, string, error) {
+	m, entry, err := findManifest(patchPath)
+	if err == nil {
+		j.Log.Info("使用补丁清单校验")
+		patchDigest, err := digestPath(patchPath)
+		if err != nil {
+			return nil, "", err
+		}
+		if patchDigest.SHA256 != entry.SHA256 {
+			return nil, "", fmt.Errorf("补丁文件与清单记录不一致，可能已损坏或被修改")
+		}
+		oldDigest, err := digestPath(oldPath)
+		if err != nil {
+			return nil, "", err
+		}
+		if src := m.Source(entry); oldDigest.SHA256 != src.SHA256 {
+			return nil, "", fmt.Errorf("旧数据与清单记录的 %s 不一致，该补丁不适用于此版本", src.Name)
+		}
+		target := m.Target(entry)
+		return &target, "清单", nil
+	}
+	if !os.IsNotExist(err) {
+		j.Log.Warnf("读取补丁清单失败 - %v", err)
+	}
+	if refPath == "" {
+		return nil, "", nil
+	}
+	j.Log.Info("使用参考数据校验: " + refPath)
+	ref, err := digestPath(refPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("计算参考数据摘要失败 - %v", err)
+	}
+	return &ref, "参考数据", nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 分卷: name.001、name.002 ... 加上索引 name.parts.json
+const partsSuffix = ".parts.json"
+
+type volumePart struct {
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// 分卷索引，记录原文件和每一卷的大小与摘要
+type volumeIndex struct {
+	Version    int          `json:"version"`
+	File       string       `json:"file"`
+	Size       int64        `json:"size"`
+	SHA256     string       `json:"sha256"`
+	VolumeSize int64        `json:"volume_size"`
+	Parts      []volumePart `json:"parts"`
+}
+
+func volumeName(path string, n int) string {
+	return fmt.Sprintf("%s.%03d", path, n)
+}
+
+// 分卷或索引文件对应的原文件路径，不是分卷时返回空
+func volumeBase(path string) string {
+	if strings.HasSuffix(path, partsSuffix) {
+		return strings.TrimSuffix(path, partsSuffix)
+	}
+	if filepath.Ext(path) == ".001" {
+		base := strings.TrimSuffix(path, ".001")
+		if getPathType(base+partsSuffix) == FileTypeFile {
+			return base
+		}
+	}
+	return ""
+}
+
+// 把文件切成固定大小的分卷并写出索引，成功后删除原文件
+func splitVolumes(path string, volumeSize int64) (*volumeIndex, error) {
+	if volumeSize <= 0 {
+		return nil, errors.New("分卷大小无效")
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := in.Stat()
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	idx := &volumeIndex{Version: 1, File: filepath.Base(path), Size: info.Size(), VolumeSize: volumeSize}
+	whole := sha256.New()
+	written := []string{}
+	fail := func(err error) (*volumeIndex, error) {
+		in.Close()
+		for _, p := range written {
+			os.Remove(p)
+		}
+		return nil, err
+	}
+	for n := 1; n == 1 || idx.sumSize() < idx.Size; n++ {
+		name := volumeName(path, n)
+		out, err := os.Create(name)
+		if err != nil {
+			return fail(err)
+		}
+		written = append(written, name)
+		h := sha256.New()
+		size, err := io.CopyN(io.MultiWriter(out, h, whole), in, volumeSize)
+		if err == io.EOF {
+			err = nil
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fail(err)
+		}
+		idx.Parts = append(idx.Parts, volumePart{File: filepath.Base(name), Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	in.Close()
+	idx.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path+partsSuffix, data, 0644)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	written = append(written, path+partsSuffix)
+	if err := os.Remove(path); err != nil {
+		return fail(err)
+	}
+	return idx, nil
+}
+
+func (idx *volumeIndex) sumSize() int64 {
+	var n int64
+	for _, p := range idx.Parts {
+		n += p.Size
+	}
+	return n
+}
+
+func loadVolumeIndex(base string) (*volumeIndex, error) {
+	data, err := os.ReadFile(base + partsSuffix)
+	if err != nil {
+		return nil, err
+	}
+	idx := &volumeIndex{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("分卷索引格式错误 - %v", err)
+	}
+	// 索引没有签名，文件名可能被改成目录外的路径
+	if !plainFileName(idx.File) {
+		return nil, fmt.Errorf("分卷索引中包含非法文件名: %s", idx.File)
+	}
+	for _, p := range idx.Parts {
+		if !plainFileName(p.File) {
+			return nil, fmt.Errorf("分卷索引中包含非法文件名: %s", p.File)
+		}
+	}
+	if idx.sumSize() != idx.Size {
+		return nil, errors.New("分卷索引中的大小与原文件不一致")
+	}
+	return idx, nil
+}
+
+// 检查所有分卷都在，且大小与索引一致
+func (idx *volumeIndex) check(dir string) error {
+	var missing []string
+	for _, p := range idx.Parts {
+		info, err := os.Stat(filepath.Join(dir, p.File))
+		if err != nil {
+			missing = append(missing, p.File)
+			continue
+		}
+		if info.Size() != p.Size {
+			return fmt.Errorf("分卷 %s 大小不正确 (期望 %d，实际 %d)", p.File, p.Size, info.Size())
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("缺少分卷: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// 边校验边把分卷拼接到 dst，只需要一份补丁大小的额外空间
+func (idx *volumeIndex) join(dir, dst string) error {
+	if err := idx.check(dir); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	whole := sha256.New()
+	for _, p := range idx.Parts {
+		if err := copyPart(io.MultiWriter(out, whole), filepath.Join(dir, p.File), p.SHA256); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if hex.EncodeToString(whole.Sum(nil)) != idx.SHA256 {
+		return errors.New("拼接结果与分卷索引记录的摘要不一致")
+	}
+	return nil
+}
+
+func copyPart(w io.Writer, path, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != expected {
+		return fmt.Errorf("分卷 %s 已损坏 (SHA256 不一致)", filepath.Base(path))
+	}
+	return nil
+}
+
+// 把分卷补丁拼接到 workDir，同名的签名和清单一起复制过去
+func (j *job) joinVolumes(base, workDir string) (string, error) {
+	idx, err := loadVolumeIndex(base)
+	if err != nil {
+		return "", err
+	}
+	j.Log.Infof("校验并拼接分卷 (%d 卷): %s", len(idx.Parts), idx.File)
+	dir := filepath.Join(workDir, idx.File+".parts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	out := filepath.Join(dir, idx.File)
+	if err := idx.join(filepath.Dir(base), out); err != nil {
+		return "", err
+	}
+	if err := copySidecars(base, out); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// 复制补丁的 .sig 和同目录的清单（及其 .sig），回退补丁的清单以正向补丁命名，所以复制全部清单
+func copySidecars(src, dst string) error {
+	pairs := [][2]string{{src + sigExt, dst + sigExt}}
+	manifests, _ := filepath.Glob(filepath.Join(filepath.Dir(src), "*"+manifestSuffix))
+	for _, m := range manifests {
+		target := filepath.Join(filepath.Dir(dst), filepath.Base(m))
+		pairs = append(pairs, [2]string{m, target}, [2]string{m + sigExt, target + sigExt})
+	}
+	for _, p := range pairs {
+		if getPathType(p[0]) != FileTypeFile {
+			continue
+		}
+		if err := copyFile(p[0], p[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}